@@ -0,0 +1,263 @@
+package bot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/platform/model"
+	"github.com/pkg/errors"
+
+	"github.com/abustany/mattermost-trac-bot/trac"
+)
+
+// ticketActions lists the buttons added to ticket attachments, by ID
+var ticketActions = []struct {
+	id   string
+	name string
+}{
+	{"assign", "Assign to me"},
+	{"close", "Close as fixed"},
+	{"cc", "Add me to CC"},
+	{"description", "Show description"},
+}
+
+// actionSignature signs the context of a button, so that the bot only acts on
+// the buttons it posted
+func (b *Bot) actionSignature(channelName, tracId, ticketNumber, action string) string {
+	mac := hmac.New(sha256.New, []byte(b.conf.ActionSecret))
+
+	for _, s := range []string{channelName, tracId, ticketNumber, action} {
+		mac.Write([]byte(s))
+		mac.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// addTicketActions adds buttons to a reply showing a single ticket attachment,
+// if the channel allows updating tickets. Replies about several tickets get no
+// buttons, since the bot could only rebuild part of them when updating the
+// post.
+func (b *Bot) addTicketActions(channelName string, message *reply) {
+	if len(b.conf.ActionURL) == 0 {
+		return
+	}
+
+	if channelConfig, _ := b.channelConfig(channelName); !channelConfig.UpdateTickets {
+		return
+	}
+
+	if len(message.tickets) != 1 || len(message.attachments) != 1 || len(strings.TrimSpace(message.String())) > 0 {
+		return
+	}
+
+	ref := message.tickets[0]
+	attachment := message.attachments[0]
+
+	for _, action := range ticketActions {
+		attachment.Actions = append(attachment.Actions, &model.PostAction{
+			Id:   action.id,
+			Name: action.name,
+			Integration: &model.PostActionIntegration{
+				URL: b.conf.ActionURL,
+				Context: model.StringInterface{
+					"action":    action.id,
+					"channel":   channelName,
+					"trac":      ref.tracId,
+					"ticket":    ref.ticketNumber,
+					"signature": b.actionSignature(channelName, ref.tracId, ref.ticketNumber, action.id),
+				},
+			},
+		})
+	}
+}
+
+// actionContext is the context of a clicked button
+type actionContext struct {
+	action      string
+	channelName string
+	ref         ticketRef
+}
+
+// parseActionContext reads the context of a clicked button, checking its
+// signature
+func (b *Bot) parseActionContext(context model.StringInterface) (actionContext, error) {
+	get := func(key string) string {
+		s, _ := context[key].(string)
+		return s
+	}
+
+	ctx := actionContext{
+		action:      get("action"),
+		channelName: get("channel"),
+		ref:         ticketRef{tracId: get("trac"), ticketNumber: get("ticket")},
+	}
+
+	signature := b.actionSignature(ctx.channelName, ctx.ref.tracId, ctx.ref.ticketNumber, ctx.action)
+
+	if !hmac.Equal([]byte(get("signature")), []byte(signature)) {
+		return ctx, errors.New("Invalid action signature")
+	}
+
+	return ctx, nil
+}
+
+// handleAction performs the action of a button clicked on a ticket attachment,
+// and updates the post with the new state of the ticket
+func (b *Bot) handleAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request model.PostActionIntegrationRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, err := b.parseActionContext(request.Context)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	var response model.PostActionIntegrationResponse
+
+	if update, text, err := b.runAction(ctx, request.UserId); err != nil {
+		response.EphemeralText = fmt.Sprintf(":x: %s", err)
+	} else {
+		response.Update = update
+		response.EphemeralText = text
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// runAction performs a ticket action for a user. It returns the updated post
+// if the ticket changed, or a text to show to the user. The ticket is redacted
+// like in the replies to messages if it is not public.
+//
+// Nothing proves that the request comes from the user it names, the action is
+// thus always performed with the shared account rather than with the personal
+// account of the user.
+func (b *Bot) runAction(ctx actionContext, userId string) (*model.Post, string, error) {
+	channelConfig, ok := b.channelConfig(ctx.channelName)
+
+	if !ok || !channelConfig.UpdateTickets {
+		return nil, "", errors.New("Updating tickets is not allowed from this channel")
+	}
+
+	tracId, client, err := b.resolveTrac(channelConfig, "", ctx.ref.tracId, "ticket #"+ctx.ref.ticketNumber)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	ticket, err := client.GetTicket(ctx.ref.ticketNumber)
+
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "Error while retrieving ticket %s#%s", tracId, ctx.ref.ticketNumber)
+	}
+
+	ref := ticketRef{tracId, ctx.ref.ticketNumber}
+
+	if ctx.action == "description" {
+		if publicErr := b.publicTickets(channelConfig, []ticketRef{ref}, []error{nil})[0]; publicErr != nil {
+			return nil, "", errors.Errorf("Ticket %s#%s is not public, its description cannot be shown in this channel", tracId, ctx.ref.ticketNumber)
+		}
+
+		return nil, fmt.Sprintf("**Description of #%s:**\n%s", ctx.ref.ticketNumber, ticket["description"]), nil
+	}
+
+	author, err := b.username(userId)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Trac knows the user by their own account if they registered one
+	me := author
+
+	if b.credentials != nil {
+		if credential, ok := b.credentials.Get(userId, tracId); ok {
+			me = credential.Username
+		}
+	}
+
+	attributes, comment, err := actionAttributes(ctx.action, ticket, me)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = client.UpdateTicket(ctx.ref.ticketNumber, attribute("", comment, author), attributes)
+
+	if errors.Cause(err) == trac.ErrTicketConflict {
+		return nil, "", errors.Errorf("Ticket %s#%s was modified by someone else in the meantime, please try again", tracId, ctx.ref.ticketNumber)
+	}
+
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "Error while updating ticket %s#%s", tracId, ctx.ref.ticketNumber)
+	}
+
+	if ticket, err = client.GetTicket(ctx.ref.ticketNumber); err != nil {
+		return nil, "", errors.Wrapf(err, "Ticket %s#%s was updated, but could not be retrieved", tracId, ctx.ref.ticketNumber)
+	}
+
+	message := &reply{}
+
+	if err := b.formatVisibleTicket(message, channelConfig, userId, ref, ticket, b.ticketChanges(channelConfig, "", ref), b.conf.TicketChanges); err != nil {
+		log.Printf("Error while formatting updated ticket: %s", err)
+		return nil, fmt.Sprintf("Ticket %s#%s was updated", tracId, ctx.ref.ticketNumber), nil
+	}
+
+	b.addTicketActions(ctx.channelName, message)
+
+	post := &model.Post{Message: message.String()}
+	post.AddProp("attachments", message.attachments)
+
+	return post, "", nil
+}
+
+// actionAttributes returns the ticket changes made by an action on behalf of
+// the Trac user me, and a summary of the change
+func actionAttributes(action string, ticket trac.Ticket, me string) (map[string]string, string, error) {
+	switch action {
+	case "assign":
+		return map[string]string{
+			"action":                         "reassign",
+			"action_reassign_reassign_owner": me,
+		}, "Assigned", nil
+	case "close":
+		return map[string]string{
+			"action":                            "resolve",
+			"action_resolve_resolve_resolution": "fixed",
+		}, "Closed", nil
+	case "cc":
+		var cc []string
+
+		for _, s := range strings.Split(ticket["cc"], ",") {
+			if s = strings.TrimSpace(s); len(s) == 0 {
+				continue
+			} else if s == me {
+				return nil, "", errors.New("You are already in CC")
+			}
+
+			cc = append(cc, s)
+		}
+
+		return map[string]string{"cc": strings.Join(append(cc, me), ", ")}, "Added to CC", nil
+	}
+
+	return nil, "", errors.Errorf("Unknown action: %s", action)
+}
@@ -0,0 +1,151 @@
+package bot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/platform/model"
+
+	"github.com/abustany/mattermost-trac-bot/config"
+	"github.com/abustany/mattermost-trac-bot/trac"
+)
+
+func TestActionAttributes(t *testing.T) {
+	attributes, _, err := actionAttributes("assign", trac.Ticket{}, "alice")
+
+	if err != nil || attributes["action"] != "reassign" || attributes["action_reassign_reassign_owner"] != "alice" {
+		t.Errorf("Unexpected assign attributes: %v (%v)", attributes, err)
+	}
+
+	attributes, _, err = actionAttributes("cc", trac.Ticket{"cc": "bob, carol"}, "alice")
+
+	if err != nil || attributes["cc"] != "bob, carol, alice" {
+		t.Errorf("Unexpected CC attributes: %v (%v)", attributes, err)
+	}
+
+	if _, _, err := actionAttributes("cc", trac.Ticket{"cc": "bob,alice"}, "alice"); err == nil {
+		t.Errorf("Adding a user already in CC should fail")
+	}
+
+	if _, _, err := actionAttributes("delete", trac.Ticket{}, "alice"); err == nil {
+		t.Errorf("Unknown actions should fail")
+	}
+}
+
+func TestTicketActions(t *testing.T) {
+	b := &Bot{conf: config.Config{
+		ActionURL:    "http://bot/action",
+		ActionSecret: "s3cret",
+		Channels: map[string]config.ChannelConfig{
+			"town-square": {UpdateTickets: true},
+			"off-topic":   {},
+		},
+	}}
+
+	newReply := func() *reply {
+		return &reply{
+			attachments: []*model.SlackAttachment{{Title: "#35: Panic"}},
+			tickets:     []ticketRef{{"trac1", "35"}},
+		}
+	}
+
+	message := newReply()
+	b.addTicketActions("off-topic", message)
+
+	if len(message.attachments[0].Actions) > 0 {
+		t.Errorf("Buttons added in a channel not allowing updates")
+	}
+
+	message = newReply()
+	message.WriteString("Some text")
+	b.addTicketActions("town-square", message)
+
+	if len(message.attachments[0].Actions) > 0 {
+		t.Errorf("Buttons added to a reply with text")
+	}
+
+	message = newReply()
+	b.addTicketActions("town-square", message)
+
+	if len(message.attachments[0].Actions) != len(ticketActions) {
+		t.Fatalf("Unexpected buttons: %v", message.attachments[0].Actions)
+	}
+
+	context := message.attachments[0].Actions[1].Integration.Context
+	ctx, err := b.parseActionContext(context)
+
+	if err != nil || ctx.action != "close" || ctx.channelName != "town-square" || ctx.ref != (ticketRef{"trac1", "35"}) {
+		t.Errorf("Unexpected action context: %+v (%v)", ctx, err)
+	}
+
+	context["ticket"] = "36"
+
+	if _, err := b.parseActionContext(context); err == nil {
+		t.Errorf("Tampered action context should be rejected")
+	}
+
+	req := httptest.NewRequest("POST", "/action", strings.NewReader(`{"user_id": "u1", "context": {"action": "close", "ticket": "35"}}`))
+	w := httptest.NewRecorder()
+	b.handleAction(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Unexpected status for unsigned action: %d", w.Code)
+	}
+}
+
+func TestDescriptionAction(t *testing.T) {
+	client, closeServer := testPublicTrac(t, "1", "2")
+	defer closeServer()
+
+	publicClient, closePublicServer := testPublicTrac(t, "1")
+	defer closePublicServer()
+
+	b := &Bot{
+		conf: config.Config{Channels: map[string]config.ChannelConfig{
+			"town-square": {TracInstances: []string{"trac1"}, UpdateTickets: true, RestrictedTickets: config.RestrictedTicketsRedact},
+		}},
+		tracs:       map[string]*trac.Client{"trac1": client},
+		publicTracs: map[string]*trac.Client{"trac1": publicClient},
+	}
+
+	ctx := actionContext{action: "description", channelName: "town-square", ref: ticketRef{"trac1", "1"}}
+
+	if _, text, err := b.runAction(ctx, "u1"); err != nil || !strings.HasPrefix(text, "**Description of #1:**") {
+		t.Errorf("Unexpected description of a public ticket: %q (%v)", text, err)
+	}
+
+	ctx.ref.ticketNumber = "2"
+
+	if _, text, err := b.runAction(ctx, "u1"); err == nil {
+		t.Errorf("The description of a non-public ticket should not be shown: %q", text)
+	}
+}
+
+func TestActionSharedAccount(t *testing.T) {
+	store, cleanup := testCredentials(t)
+	defer cleanup()
+
+	sharedClient, closeSharedServer := testPublicTrac(t, "1")
+	defer closeSharedServer()
+
+	personalClient, closePersonalServer := testPublicTrac(t, "1", "2")
+	defer closePersonalServer()
+
+	b := &Bot{
+		conf: config.Config{Channels: map[string]config.ChannelConfig{
+			"town-square": {TracInstances: []string{"trac1"}, UpdateTickets: true},
+		}},
+		credentials: store,
+		tracs:       map[string]*trac.Client{"trac1": sharedClient},
+		userTracs:   map[string]map[string]*trac.Client{"u1": {"trac1": personalClient}},
+	}
+
+	// Anyone can send an action naming u1, their account must not be used
+	ctx := actionContext{action: "description", channelName: "town-square", ref: ticketRef{"trac1", "2"}}
+
+	if _, text, err := b.runAction(ctx, "u1"); err == nil {
+		t.Errorf("Actions should not use personal accounts, got %q", text)
+	}
+}
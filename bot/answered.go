@@ -0,0 +1,242 @@
+package bot
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/mattermost/platform/model"
+	"github.com/pkg/errors"
+
+	"github.com/abustany/mattermost-trac-bot/config"
+)
+
+// Number of posts remembered by answeredPosts, the edits of older posts are
+// ignored
+const answeredPostsCapacity = 1000
+
+// answeredPost is a post handled by the bot
+type answeredPost struct {
+	// Text of the post when it was last answered
+	message string
+
+	// IDs of the replies of the bot, the first one answering the post and the
+	// others its edits. Replies posted through an incoming webhook are
+	// missing, since their ID is unknown.
+	replyIds []string
+
+	// Whether the post was a command addressed to the bot, which is not run
+	// again when edited
+	command bool
+
+	// Whether the first reply holds attachments, which cannot be changed when
+	// editing it
+	attachments bool
+}
+
+// answeredPosts remembers the latest posts handled by the bot, so that it can
+// answer their edits and delete its replies along with them
+type answeredPosts struct {
+	sync.Mutex
+
+	posts map[string]answeredPost
+
+	// Post IDs, oldest first
+	order []string
+}
+
+func newAnsweredPosts() *answeredPosts {
+	return &answeredPosts{posts: map[string]answeredPost{}}
+}
+
+func (a *answeredPosts) get(postId string) (answeredPost, bool) {
+	a.Lock()
+	defer a.Unlock()
+
+	post, ok := a.posts[postId]
+
+	return post, ok
+}
+
+// set records the answer to a post, forgetting the oldest post if there are
+// too many
+func (a *answeredPosts) set(postId string, post answeredPost) {
+	a.Lock()
+	defer a.Unlock()
+
+	if _, ok := a.posts[postId]; !ok {
+		a.order = append(a.order, postId)
+	}
+
+	a.posts[postId] = post
+
+	if len(a.order) > answeredPostsCapacity {
+		delete(a.posts, a.order[0])
+		a.order = a.order[1:]
+	}
+}
+
+// remove forgets a post
+func (a *answeredPosts) remove(postId string) {
+	a.Lock()
+	defer a.Unlock()
+
+	if _, ok := a.posts[postId]; !ok {
+		return
+	}
+
+	delete(a.posts, postId)
+
+	for i, id := range a.order {
+		if id == postId {
+			a.order = append(a.order[:i], a.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// newReferences returns the references to Trac objects found in text but not
+// in previous, the text of the post before it was edited
+func newReferences(previous, text string) []string {
+	var refs []string
+
+	previous, text = withoutCode(previous), withoutCode(text)
+
+	for _, re := range REFERENCE_RES {
+		known := map[string]int{}
+
+		for _, ref := range re.FindAllString(previous, -1) {
+			known[strings.TrimSpace(ref)]++
+		}
+
+		for _, ref := range re.FindAllString(text, -1) {
+			ref = strings.TrimSpace(ref)
+
+			if known[ref] > 0 {
+				known[ref]--
+			} else {
+				refs = append(refs, ref)
+			}
+		}
+	}
+
+	return refs
+}
+
+// handleEditedMessage answers the references added to a post when it was
+// edited. The previous reply of the bot is updated if the channel asks for it,
+// otherwise the new references are answered in a new reply.
+func (b *Bot) handleEditedMessage(post *model.Post) error {
+	answered, ok := b.answered.get(post.Id)
+
+	if !ok || answered.command {
+		return nil
+	}
+
+	refs := newReferences(answered.message, post.Message)
+
+	if len(refs) == 0 {
+		return nil
+	}
+
+	channelName := b.channelName(post.ChannelId)
+	channelConfig, _ := b.channelConfig(channelName)
+	channelConfig = b.sharedChannelConfig(channelConfig, post.UserId)
+
+	// Answering has side effects (Trac requests, direct messages), so whether
+	// the reply can be edited is decided first. Attachments cannot be changed
+	// when editing a post.
+	if channelConfig.EditReplies && len(answered.replyIds) > 0 && !answered.attachments && !b.attachesTickets(channelConfig, post.Message) {
+		message := &reply{}
+
+		if err := b.handleReferences(message, channelConfig, post.UserId, post.Message); err != nil {
+			return err
+		}
+
+		answered.message = post.Message
+		b.answered.set(post.Id, answered)
+
+		return b.updateReply(channelName, answered.replyIds[0], message)
+	}
+
+	message := &reply{}
+
+	if err := b.handleReferences(message, channelConfig, post.UserId, strings.Join(refs, " ")); err != nil {
+		return err
+	}
+
+	answered.message = post.Message
+
+	if message.empty() {
+		b.answered.set(post.Id, answered)
+		return nil
+	}
+
+	message.rootId = replyRootId(channelConfig, post)
+	replyId, err := b.postMessage(channelName, replyTrac(channelConfig, post.Message), message)
+
+	if len(replyId) > 0 {
+		answered.replyIds = append(answered.replyIds, replyId)
+	}
+
+	b.answered.set(post.Id, answered)
+
+	return err
+}
+
+// attachesTickets tells whether some of the tickets mentioned in text may be
+// shown as attachments
+func (b *Bot) attachesTickets(channelConfig config.ChannelConfig, text string) bool {
+	tracIds := makeTracIds(b.conf.Tracs)
+
+	for _, match := range TICKET_RE.FindAllStringSubmatch(withoutCode(text), -1) {
+		ref := ticketRef{tracId: match[1], ticketNumber: match[2]}
+
+		if b.conf.Tracs[tracIds[strings.ToLower(ref.trac(channelConfig))]].Attachments != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// updateReply replaces the text of a reply of the bot
+func (b *Bot) updateReply(channelName string, replyId string, message *reply) error {
+	post := model.Post{}
+	post.Id = replyId
+	post.ChannelId = b.channelId(channelName)
+	post.Message = message.String()
+
+	if _, err := b.client.UpdatePost(&post); err != nil {
+		return errors.Wrapf(err, "Error while updating reply %s on channel %s", replyId, channelName)
+	}
+
+	return nil
+}
+
+// handleDeletedMessage deletes the replies of the bot to a deleted post, unless
+// the channel keeps them
+func (b *Bot) handleDeletedMessage(post *model.Post) error {
+	answered, ok := b.answered.get(post.Id)
+
+	if !ok {
+		return nil
+	}
+
+	b.answered.remove(post.Id)
+
+	channelName := b.channelName(post.ChannelId)
+
+	if channelConfig, _ := b.channelConfig(channelName); channelConfig.KeepReplies {
+		return nil
+	}
+
+	var err error
+
+	for _, replyId := range answered.replyIds {
+		if _, deleteErr := b.client.DeletePost(post.ChannelId, replyId); deleteErr != nil && err == nil {
+			err = errors.Wrapf(deleteErr, "Error while deleting reply %s on channel %s", replyId, channelName)
+		}
+	}
+
+	return err
+}
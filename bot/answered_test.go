@@ -0,0 +1,92 @@
+package bot
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/abustany/mattermost-trac-bot/config"
+)
+
+func TestNewReferences(t *testing.T) {
+	for _, test := range []struct {
+		previous string
+		text     string
+		refs     []string
+	}{
+		{"see #3", "see #35", []string{"#35"}},
+		{"see #35", "see #35, really", nil},
+		{"see #35", "see #35 and #35", []string{"#35"}},
+		{"see #35 and wiki:Page", "see trac2#35 and wiki:Page and r1234", []string{"trac2#35", "r1234"}},
+		{"see #35 and #36", "see #36", nil},
+		{"see #35", "see #35 and [1234]", []string{"[1234]"}},
+		{"see #35", "see #35 and `arr[12]` or list[12]", nil},
+		{"see #35", "see #35 and {7}, not `{8}` or \\d{3}", []string{"{7}"}},
+	} {
+		if refs := newReferences(test.previous, test.text); !reflect.DeepEqual(refs, test.refs) {
+			t.Errorf("Unexpected new references from %q to %q: %v", test.previous, test.text, refs)
+		}
+	}
+}
+
+func TestAttachesTickets(t *testing.T) {
+	b := &Bot{conf: config.Config{Tracs: map[string]config.TracConfig{
+		"Trac1": {Attachments: &config.AttachmentConfig{}},
+		"trac2": {},
+	}}}
+
+	channelConfig := config.ChannelConfig{DefaultTracInstance: "Trac1"}
+
+	for _, test := range []struct {
+		text     string
+		attaches bool
+	}{
+		{"see #35", true},
+		{"see trac1#35", true},
+		{"see trac2#35 and wiki:Page", false},
+		{"see trac2#35 and `#36`", false},
+		{"see trac2#35 and #36", true},
+	} {
+		if attaches := b.attachesTickets(channelConfig, test.text); attaches != test.attaches {
+			t.Errorf("Unexpected attachments for %q: %v, expected %v", test.text, attaches, test.attaches)
+		}
+	}
+}
+
+func TestAnsweredPosts(t *testing.T) {
+	a := newAnsweredPosts()
+
+	for i := 0; i < answeredPostsCapacity+10; i++ {
+		a.set(fmt.Sprintf("post%d", i), answeredPost{message: "#35"})
+	}
+
+	a.set("post20", answeredPost{message: "#36", replyIds: []string{"reply20"}})
+
+	if _, ok := a.get("post9"); ok {
+		t.Errorf("Oldest posts should be forgotten")
+	}
+
+	if post, ok := a.get("post20"); !ok || post.message != "#36" || len(post.replyIds) != 1 {
+		t.Errorf("Unexpected answered post: %+v", post)
+	}
+
+	if len(a.posts) != answeredPostsCapacity || len(a.order) != answeredPostsCapacity {
+		t.Errorf("Unexpected number of remembered posts: %d", len(a.posts))
+	}
+}
+
+func TestAnsweredPostsRemove(t *testing.T) {
+	a := newAnsweredPosts()
+	a.set("post1", answeredPost{message: "#35"})
+	a.set("post2", answeredPost{message: "#36"})
+	a.remove("post1")
+	a.remove("post3")
+
+	if _, ok := a.get("post1"); ok {
+		t.Errorf("Removed post should be forgotten")
+	}
+
+	if _, ok := a.get("post2"); !ok || !reflect.DeepEqual(a.order, []string{"post2"}) {
+		t.Errorf("Unexpected remembered posts: %v", a.order)
+	}
+}
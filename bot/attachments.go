@@ -0,0 +1,78 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/platform/model"
+
+	"github.com/abustany/mattermost-trac-bot/config"
+	"github.com/abustany/mattermost-trac-bot/trac"
+)
+
+// ticketAttachment shows a ticket as a message attachment laid out as
+// configured for its Trac instance. The latest comment of changes, if any, is
+// used as the attachment text, and fallback is shown by the clients not
+// supporting attachments.
+func ticketAttachment(conf *config.AttachmentConfig, t trac.Ticket, changes []trac.TicketChange, fallback string) *model.SlackAttachment {
+	attachment := &model.SlackAttachment{
+		Fallback:  fallback,
+		Title:     fmt.Sprintf("#%s: %s", t["id"], t["summary"]),
+		TitleLink: t["_url"],
+	}
+
+	if comment := trac.LastComment(changes); comment != nil {
+		attachment.Text = fmt.Sprintf("%s: %s", comment.Author, comment.NewValue)
+	}
+
+	for _, rule := range conf.Colors {
+		if t[rule.Field] == rule.Value {
+			attachment.Color = rule.Color
+			break
+		}
+	}
+
+	for _, field := range conf.Fields {
+		value := t[field.Field]
+
+		if len(value) == 0 {
+			continue
+		}
+
+		title := field.Title
+
+		if len(title) == 0 {
+			title = field.Field
+		}
+
+		attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+			Title: title,
+			Value: value,
+			Short: field.Short,
+		})
+	}
+
+	return attachment
+}
+
+// formatTicket adds a ticket to message, as an attachment if its Trac
+// instance is configured for it, with ticket_template otherwise
+func (b *Bot) formatTicket(message *reply, tracId string, t trac.Ticket, changes []trac.TicketChange, maxChanges int) error {
+	conf := b.conf.Tracs[makeTracIds(b.conf.Tracs)[strings.ToLower(tracId)]].Attachments
+
+	if conf == nil {
+		return formatTicketMessage(message, b.ticketTemplate, t, changes, maxChanges)
+	}
+
+	fallback := bytes.NewBuffer(nil)
+
+	if err := formatTicketMessage(fallback, b.ticketTemplate, t, changes, maxChanges); err != nil {
+		return err
+	}
+
+	message.attachments = append(message.attachments, ticketAttachment(conf, t, changes, fallback.String()))
+	message.tickets = append(message.tickets, ticketRef{tracId, t["id"]})
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/abustany/mattermost-trac-bot/config"
+	"github.com/abustany/mattermost-trac-bot/trac"
+)
+
+func TestTicketAttachment(t *testing.T) {
+	conf := &config.AttachmentConfig{
+		Fields: []config.AttachmentFieldConfig{
+			{Field: "owner", Title: "Owner", Short: true},
+			{Field: "milestone", Short: true},
+			{Field: "component"},
+		},
+		Colors: []config.AttachmentColorConfig{
+			{Field: "priority", Value: "blocker", Color: "#ff0000"},
+			{Field: "status", Value: "new", Color: "#0000ff"},
+		},
+	}
+
+	ticket := trac.Ticket{
+		"id":        "35",
+		"summary":   "Panic in backend code",
+		"status":    "new",
+		"priority":  "blocker",
+		"owner":     "alice",
+		"milestone": "1.4",
+		"_url":      "http://trac/ticket/35",
+	}
+
+	changes := []trac.TicketChange{
+		{Author: "bob", Field: "comment", NewValue: "Still crashing"},
+		{Author: "alice", Field: "owner", NewValue: "alice"},
+	}
+
+	attachment := ticketAttachment(conf, ticket, changes, "Ticket 35")
+
+	if attachment.Title != "#35: Panic in backend code" || attachment.TitleLink != "http://trac/ticket/35" || attachment.Fallback != "Ticket 35" {
+		t.Errorf("Unexpected attachment title: %+v", attachment)
+	}
+
+	if attachment.Color != "#ff0000" {
+		t.Errorf("Unexpected attachment colour: %s", attachment.Color)
+	}
+
+	if attachment.Text != "bob: Still crashing" {
+		t.Errorf("Unexpected attachment text: %s", attachment.Text)
+	}
+
+	if len(attachment.Fields) != 2 {
+		t.Fatalf("Unexpected attachment fields: %v", attachment.Fields)
+	}
+
+	if f := attachment.Fields[0]; f.Title != "Owner" || f.Value != "alice" || !f.Short {
+		t.Errorf("Unexpected owner field: %+v", f)
+	}
+
+	if f := attachment.Fields[1]; f.Title != "milestone" || f.Value != "1.4" || !f.Short {
+		t.Errorf("Unexpected milestone field: %+v", f)
+	}
+
+	ticket["priority"] = "major"
+
+	if attachment := ticketAttachment(conf, ticket, nil, ""); attachment.Color != "#0000ff" || len(attachment.Text) > 0 {
+		t.Errorf("Unexpected attachment: %+v", attachment)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"regexp"
 	"strings"
 	"sync"
@@ -14,17 +15,27 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/abustany/mattermost-trac-bot/config"
+	"github.com/abustany/mattermost-trac-bot/credentials"
 	"github.com/abustany/mattermost-trac-bot/trac"
 )
 
 type Bot struct {
 	sync.Mutex
 
-	conf           config.Config
-	ticketTemplate *template.Template
-	client         *model.Client
-	wsClient       *model.WebSocketClient
-	user           *model.User
+	conf              config.Config
+	ticketTemplate    *template.Template
+	queryTemplate     *template.Template
+	wikiTemplate      *template.Template
+	changesetTemplate *template.Template
+	milestoneTemplate *template.Template
+	reportTemplate    *template.Template
+	searchTemplate    *template.Template
+	timelineTemplates map[string]*template.Template
+	client            *model.Client
+	wsClient          *model.WebSocketClient
+
+	// Replaced on each login, read with botUser
+	user *model.User
 
 	// Base data loaded just after connecting
 	globalInfo *model.InitialLoad
@@ -35,14 +46,74 @@ type Bot struct {
 	// Maps a channel ID to its name
 	channelNames map[string]string
 
-	// Maps normalized trac IDs to original ones
+	// Maps channel names to their settings, resolved from the configuration
+	channelConfigs map[string]config.ChannelConfig
+
+	// Rules configuring the channels not listed in the configuration
+	channelRules []channelRule
+
+	// Maps the ID of a direct or group message channel of the bot to its type
+	directChannels map[string]string
+
+	// Maps normalized trac IDs to the clients using the shared account
 	tracs map[string]*trac.Client
+
+	// Maps normalized trac IDs to the clients using the public identity, used
+	// to check whether tickets can be shown to everyone
+	publicTracs map[string]*trac.Client
+
+	debug bool
+
+	// Trac credentials registered by users, nil if disabled
+	credentials *credentials.Store
+
+	// Maps a Mattermost user ID and a normalized Trac ID to a client using the
+	// credentials of that user
+	userTracs     map[string]map[string]*trac.Client
+	userTracsLock sync.Mutex
+
+	// Closed when the bot shuts down
+	quit chan struct{}
+
+	// Serves the HTTP endpoints, nil if disabled
+	httpServer *http.Server
+
+	// Latest posts answered by the bot
+	answered *answeredPosts
+
+	// Creation time of the last post received from the WebSocket, in
+	// milliseconds, from which missed posts are backfilled after a
+	// reconnection
+	lastPostAt int64
 }
 
 var TICKET_RE = regexp.MustCompile(`([a-zA-Z0-9]+)?#(\d+)`)
 
+// QUERY_RE matches TracQuery links, eg. query:status=new&owner=alice,
+// [query:?milestone=1.2 label] or trac1:query:status=new
+var QUERY_RE = regexp.MustCompile(`(?:\b([a-zA-Z0-9]+):)?query:([^\s\]]+)`)
+
+// WIKI_RE matches wiki page links, eg. wiki:PageName, [wiki:PageName label] or
+// trac1:wiki:PageName
+var WIKI_RE = regexp.MustCompile(`(?:\b([a-zA-Z0-9]+):)?wiki:([^\s\]#?]+)`)
+
+// CHANGESET_RE matches changeset references: changeset:abc123, r1234 (both
+// optionally prefixed by a Trac ID and followed by a repository name, as in
+// trac1:r1234/repo), and [1234] or [abc1234/repo]. The bracketed forms must
+// follow a space or start the text, so that code such as arr[0] is ignored.
+var CHANGESET_RE = regexp.MustCompile(`(?:\b([a-zA-Z0-9]+):)?(?:changeset:([0-9a-fA-F]+)|\br(\d+))(?:/([\w.-]+))?\b|(?:^|\s)\[(\d+|[0-9a-f]{7,40})(?:/([\w.-]+))?\]`)
+
+// MILESTONE_RE matches milestone links: milestone:1.4, milestone:"Next release"
+// or trac1:milestone:1.4
+var MILESTONE_RE = regexp.MustCompile(`(?:\b([a-zA-Z0-9]+):)?milestone:(?:"([^"]+)"|([^\s\]]+))`)
+
+// REPORT_RE matches saved report links: report:7, trac1:report:7 or {7}, the
+// latter following a space or starting the text so that \d{3} is ignored
+var REPORT_RE = regexp.MustCompile(`(?:\b([a-zA-Z0-9]+):)?report:(\d+)|(?:^|\s)\{(\d+)\}`)
+
 func New(conf config.Config, debug bool) (*Bot, error) {
 	tracs := map[string]*trac.Client{}
+	publicTracs := map[string]*trac.Client{}
 
 	ticketTemplate, err := template.New("ticket").Parse(conf.TicketTemplate)
 
@@ -50,6 +121,50 @@ func New(conf config.Config, debug bool) (*Bot, error) {
 		return nil, errors.Wrap(err, "Error while compiling ticket formatting template")
 	}
 
+	queryTemplate, err := template.New("query").Parse(conf.QueryTemplate)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while compiling query formatting template")
+	}
+
+	wikiTemplate, err := template.New("wiki").Parse(conf.WikiTemplate)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while compiling wiki formatting template")
+	}
+
+	changesetTemplate, err := template.New("changeset").Parse(conf.ChangesetTemplate)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while compiling changeset formatting template")
+	}
+
+	milestoneTemplate, err := template.New("milestone").Parse(conf.MilestoneTemplate)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while compiling milestone formatting template")
+	}
+
+	reportTemplate, err := template.New("report").Parse(conf.ReportTemplate)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while compiling report formatting template")
+	}
+
+	searchTemplate, err := template.New("search").Parse(conf.SearchTemplate)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while compiling search formatting template")
+	}
+
+	timelineTemplates := make(map[string]*template.Template, len(conf.TimelineTemplates))
+
+	for kind, text := range conf.TimelineTemplates {
+		if timelineTemplates[kind], err = template.New("timeline-" + kind).Parse(text); err != nil {
+			return nil, errors.Wrapf(err, "Error while compiling %s timeline formatting template", kind)
+		}
+	}
+
 	for name, config := range conf.Tracs {
 		id := strings.ToLower(name)
 
@@ -57,39 +172,102 @@ func New(conf config.Config, debug bool) (*Bot, error) {
 			return nil, errors.Errorf("Conflicting Trac name for %s", name)
 		}
 
-		authType, err := trac.ParseAuthType(config.AuthType)
+		log.Printf("Setting up Trac client %s with auth %s", name, config.AuthType)
+
+		client, err := newTracClient(config, config.Username, config.Password, debug)
 
 		if err != nil {
-			return nil, errors.Wrapf(err, "Invalid authentication type for Trac %s", name)
+			return nil, errors.Wrapf(err, "Error while setting up Trac %s", name)
 		}
 
-		log.Printf("Setting up Trac client %s with auth %s", name, config.AuthType)
+		tracs[id] = client
 
-		client, err := trac.New(config.URL, authType, debug)
+		if publicTracs[id], err = newTracClient(config, config.PublicUsername, config.PublicPassword, debug); err != nil {
+			return nil, errors.Wrapf(err, "Error while setting up public identity for Trac %s", name)
+		}
+	}
+
+	var store *credentials.Store
+
+	if len(conf.CredentialsFile) > 0 {
+		key, err := credentials.ParseKey(conf.CredentialsKey)
 
 		if err != nil {
-			return nil, errors.Wrap(err, "Error while initializing Trac client")
+			return nil, errors.Wrap(err, "Invalid credentials key")
 		}
 
-		client.SetInsecure(config.Insecure)
-
-		if err := client.Authenticate(config.Username, config.Password); err != nil {
-			return nil, errors.Wrapf(err, "Authentication error for Trac %s", name)
+		if store, err = credentials.Open(conf.CredentialsFile, key); err != nil {
+			return nil, errors.Wrap(err, "Error while loading user credentials")
 		}
+	}
 
-		tracs[id] = client
+	channelRules, err := compileChannelRules(conf.ChannelRules)
+
+	if err != nil {
+		return nil, err
 	}
 
 	return &Bot{
-		conf:           conf,
-		ticketTemplate: ticketTemplate,
-		client:         model.NewClient(conf.Server),
-		channels:       map[string]*model.Channel{},
-		channelNames:   map[string]string{},
-		tracs:          tracs,
+		conf:              conf,
+		ticketTemplate:    ticketTemplate,
+		queryTemplate:     queryTemplate,
+		wikiTemplate:      wikiTemplate,
+		changesetTemplate: changesetTemplate,
+		milestoneTemplate: milestoneTemplate,
+		reportTemplate:    reportTemplate,
+		searchTemplate:    searchTemplate,
+		timelineTemplates: timelineTemplates,
+		client:            model.NewClient(conf.Server),
+		channels:          map[string]*model.Channel{},
+		channelNames:      map[string]string{},
+		channelConfigs:    map[string]config.ChannelConfig{},
+		channelRules:      channelRules,
+		directChannels:    map[string]string{},
+		tracs:             tracs,
+		publicTracs:       publicTracs,
+		debug:             debug,
+		credentials:       store,
+		userTracs:         map[string]map[string]*trac.Client{},
+		quit:              make(chan struct{}),
+		answered:          newAnsweredPosts(),
 	}, nil
 }
 
+// newTracClient sets up a client for a Trac instance, authenticated with the
+// given credentials, or anonymous if username is empty
+func newTracClient(config config.TracConfig, username, password string, debug bool) (*trac.Client, error) {
+	authType, err := trac.ParseAuthType(config.AuthType)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid authentication type")
+	}
+
+	backend, err := trac.ParseBackend(config.Backend)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid backend")
+	}
+
+	client, err := trac.New(config.URL, authType, debug)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while initializing Trac client")
+	}
+
+	client.SetInsecure(config.Insecure)
+	client.SetBackend(backend)
+
+	if len(username) == 0 {
+		return client, nil
+	}
+
+	if err := client.Authenticate(username, password); err != nil {
+		return nil, errors.Wrapf(err, "Authentication error as %s", username)
+	}
+
+	return client, nil
+}
+
 func makeTracIds(ids map[string]config.TracConfig) map[string]string {
 	normalizedIds := make(map[string]string, len(ids))
 
@@ -107,11 +285,8 @@ func (b *Bot) Run() error {
 		log.Printf("Mattermost server version %s", props["version"])
 	}
 
-	if res, err := b.client.Login(b.conf.Username, b.conf.Password); err != nil {
-		return errors.Wrapf(err, "Error while logging in as %s", b.conf.Username)
-	} else {
-		log.Printf("Logged in as %s", b.conf.Username)
-		b.user = res.Data.(*model.User)
+	if err := b.login(); err != nil {
+		return err
 	}
 
 	if res, err := b.client.GetInitialLoad(); err != nil {
@@ -140,6 +315,14 @@ func (b *Bot) Run() error {
 		return errors.Wrap(err, "Error while setting up channels")
 	}
 
+	if err := b.startHTTPServer(); err != nil {
+		return errors.Wrap(err, "Error while starting HTTP server")
+	}
+
+	if err := b.startTimeline(); err != nil {
+		return errors.Wrap(err, "Error while setting up timeline subscriptions")
+	}
+
 	if err := b.handleWebSocket(); err != nil {
 		return errors.Wrap(err, "Error while starting WebSockets client")
 	}
@@ -147,6 +330,34 @@ func (b *Bot) Run() error {
 	return nil
 }
 
+// login opens a session on the Mattermost server
+func (b *Bot) login() error {
+	res, err := b.client.Login(b.conf.Username, b.conf.Password)
+
+	if err != nil {
+		return errors.Wrapf(err, "Error while logging in as %s", b.conf.Username)
+	}
+
+	log.Printf("Logged in as %s", b.conf.Username)
+
+	// The HTTP handlers read it while reconnecting
+	b.Lock()
+	b.user = res.Data.(*model.User)
+	b.Unlock()
+
+	return nil
+}
+
+// botUser returns the Mattermost user of the bot
+func (b *Bot) botUser() *model.User {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.user
+}
+
+// loadChannels lists the channels the bot is a member of, and serves those
+// that are configured
 func (b *Bot) loadChannels() error {
 	res, err := b.client.GetChannels("")
 
@@ -154,113 +365,286 @@ func (b *Bot) loadChannels() error {
 		return errors.Wrap(err, "Error while listing channels")
 	}
 
+	channels := map[string]*model.Channel{}
+	channelNames := map[string]string{}
+	channelConfigs := map[string]config.ChannelConfig{}
+	directChannels := map[string]string{}
+
 	for _, serverChan := range *res.Data.(*model.ChannelList) {
-		if _, ok := b.conf.Channels[serverChan.Name]; ok {
-			b.channels[serverChan.Name] = serverChan
-			b.channelNames[serverChan.Id] = serverChan.Name
+		if serverChan.IsGroupOrDirect() {
+			directChannels[serverChan.Id] = serverChan.Type
+			continue
+		}
+
+		if channelConfig, ok := b.resolveChannel(serverChan); ok {
+			channels[serverChan.Name] = serverChan
+			channelNames[serverChan.Id] = serverChan.Name
+			channelConfigs[serverChan.Name] = channelConfig
 		}
 	}
 
-	for c, _ := range b.conf.Channels {
-		if _, ok := b.channels[c]; !ok {
-			return errors.Errorf("No channel %s on server", c)
+	// The bot may be invited later
+	for c := range b.conf.Channels {
+		if _, ok := channels[c]; !ok {
+			log.Printf("Channel %s does not exist or the bot is not a member of it", c)
 		}
 	}
 
+	log.Printf("Serving %d channels", len(channels))
+
+	b.Lock()
+	b.channels = channels
+	b.channelNames = channelNames
+	b.channelConfigs = channelConfigs
+	b.directChannels = directChannels
+	b.Unlock()
+
 	return nil
 }
 
-func (b *Bot) handleWebSocket() error {
-	if !strings.HasPrefix(b.conf.Server, "http") || len(b.conf.Server) < 5 {
-		return errors.Errorf("Server URL is not HTTP?!")
+func stringSliceContainsNC(slice []string, needle string) bool {
+	needle = strings.ToLower(needle)
+
+	for _, s := range slice {
+		if strings.ToLower(s) == needle {
+			return true
+		}
 	}
 
-	wsUrl := "ws" + b.conf.Server[4:]
+	return false
+}
 
-	log.Printf("Connecting to %s", wsUrl)
+func (b *Bot) handleMessage(post *model.Post) error {
+	channelName := b.channelName(post.ChannelId)
+	channelConfig, _ := b.channelConfig(channelName)
+	channelConfig = b.sharedChannelConfig(channelConfig, post.UserId)
 
-	if wsClient, err := model.NewWebSocketClient(wsUrl, b.client.AuthToken); err != nil {
-		return errors.Wrapf(err, "Error while establishing connection to %s", wsUrl)
-	} else {
-		b.Lock()
-		b.wsClient = wsClient
-		b.Unlock()
+	message := &reply{}
+	handled, err := b.handleCommand(message, channelConfig, post)
+
+	if err != nil {
+		return err
+	} else if !handled {
+		if err := b.handleReferences(message, channelConfig, post.UserId, post.Message); err != nil {
+			return err
+		}
 	}
 
-	b.wsClient.Listen()
+	answered := answeredPost{message: post.Message, command: handled, attachments: len(message.attachments) > 0}
 
-	for ev := range b.wsClient.EventChannel {
-		if _, ok := b.channelNames[ev.Broadcast.ChannelId]; !ok {
-			continue
+	if message.empty() {
+		b.answered.set(post.Id, answered)
+		return nil
+	}
+
+	message.rootId = replyRootId(channelConfig, post)
+	replyId, err := b.postMessage(channelName, replyTrac(channelConfig, post.Message), message)
+
+	if len(replyId) > 0 {
+		answered.replyIds = []string{replyId}
+	}
+
+	b.answered.set(post.Id, answered)
+
+	return err
+}
+
+// handleReferences writes the information about the Trac objects mentioned in
+// text to message
+func (b *Bot) handleReferences(message *reply, channelConfig config.ChannelConfig, userId string, text string) error {
+	text = withoutCode(text)
+
+	if err := b.handleTicketReferences(message, channelConfig, userId, text); err != nil {
+		return err
+	}
+
+	if err := b.handleQueryReferences(message, channelConfig, userId, text); err != nil {
+		return err
+	}
+
+	if err := b.handleWikiReferences(message, channelConfig, userId, text); err != nil {
+		return err
+	}
+
+	if err := b.handleChangesetReferences(message, channelConfig, userId, text); err != nil {
+		return err
+	}
+
+	if err := b.handleMilestoneReferences(message, channelConfig, userId, text); err != nil {
+		return err
+	}
+
+	if err := b.handleReportReferences(message, channelConfig, userId, text); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (b *Bot) handleTicketReferences(message *reply, channelConfig config.ChannelConfig, userId string, text string) error {
+	matches := TICKET_RE.FindAllStringSubmatch(text, -1)
+
+	if matches == nil {
+		return nil
+	}
+
+	refs := make([]ticketRef, len(matches))
+
+	for i, match := range matches {
+		refs[i] = ticketRef{tracId: match[1], ticketNumber: match[2]}
+	}
+
+	tickets, errs := b.handleTicketRequests(channelConfig, userId, refs)
+	public := b.publicTickets(channelConfig, refs, errs)
+	private := bytes.NewBuffer(nil)
+
+	for i, ticket := range tickets {
+		var err error
+
+		attachments := len(message.attachments)
+
+		switch {
+		case errs[i] != nil:
+			err = formatErrorMessage(message, errs[i])
+		case public[i] != nil:
+			err = b.formatRestrictedTicket(message, private, channelConfig, userId, refs[i], ticket, public[i])
+		default:
+			err = b.formatTicket(message, refs[i].trac(channelConfig), ticket, b.ticketChanges(channelConfig, userId, refs[i]), b.conf.TicketChanges)
 		}
 
-		if ev.Event != model.WEBSOCKET_EVENT_POSTED {
-			continue
+		if err != nil {
+			return errors.Wrap(err, "Error while formatting ticket data")
 		}
 
-		post := model.PostFromJson(strings.NewReader(ev.Data["post"].(string)))
+		// Attachments are laid out by Mattermost
+		if len(message.attachments) == attachments {
+			message.WriteString("\n")
+		}
+	}
 
-		if post == nil || post.UserId == b.user.Id {
-			continue
+	if private.Len() > 0 {
+		if err := b.sendDirectMessage(userId, private.String()); err != nil {
+			return errors.Wrap(err, "Error while sending restricted tickets")
 		}
+	}
+
+	return nil
+}
+
+func (b *Bot) handleQueryReferences(message *reply, channelConfig config.ChannelConfig, userId string, text string) error {
+	for _, match := range QUERY_RE.FindAllStringSubmatch(text, -1) {
+		// Punctuation ending a sentence is not part of the query
+		query := strings.TrimRight(match[2], ".,;")
 
-		if err := b.handleMessage(post); err != nil {
-			log.Printf("Error while handling post %s: %s", post.Id, err)
+		result, hidden, err := b.handleQueryRequest(channelConfig, userId, match[1], query)
+
+		if err != nil {
+			err = formatErrorMessage(message, err)
+		} else if err = formatQueryMessage(message, b.queryTemplate, query, result); err == nil {
+			formatHiddenTickets(message, hidden)
 		}
+
+		if err != nil {
+			return errors.Wrap(err, "Error while formatting query results")
+		}
+
+		message.WriteString("\n")
 	}
 
 	return nil
 }
 
-func stringSliceContainsNC(slice []string, needle string) bool {
-	needle = strings.ToLower(needle)
+func (b *Bot) handleWikiReferences(message *reply, channelConfig config.ChannelConfig, userId string, text string) error {
+	for _, match := range WIKI_RE.FindAllStringSubmatch(text, -1) {
+		pageName := strings.TrimRight(match[2], ".,;")
 
-	for _, s := range slice {
-		if strings.ToLower(s) == needle {
-			return true
+		page, err := b.handleWikiRequest(channelConfig, userId, match[1], pageName)
+
+		if err != nil {
+			err = formatErrorMessage(message, err)
+		} else {
+			err = formatWikiMessage(message, b.wikiTemplate, page)
+		}
+
+		if err != nil {
+			return errors.Wrap(err, "Error while formatting wiki page")
 		}
+
+		message.WriteString("\n")
 	}
 
-	return false
+	return nil
 }
 
-func (b *Bot) handleMessage(post *model.Post) error {
-	matches := TICKET_RE.FindAllStringSubmatch(post.Message, -1)
+func (b *Bot) handleChangesetReferences(message *reply, channelConfig config.ChannelConfig, userId string, text string) error {
+	for _, match := range CHANGESET_RE.FindAllStringSubmatch(text, -1) {
+		tracId, revision, repository := match[1], match[2]+match[3], match[4]
 
-	if matches == nil {
-		return nil
+		if len(match[5]) > 0 {
+			revision, repository = match[5], match[6]
+		}
+
+		changeset, err := b.handleChangesetRequest(channelConfig, userId, tracId, revision, repository)
+
+		if err != nil {
+			err = formatErrorMessage(message, err)
+		} else {
+			err = formatChangesetMessage(message, b.changesetTemplate, changeset)
+		}
+
+		if err != nil {
+			return errors.Wrap(err, "Error while formatting changeset")
+		}
+
+		message.WriteString("\n")
 	}
 
-	channelName := b.channelNames[post.ChannelId]
-	channelConfig := b.conf.Channels[channelName]
+	return nil
+}
 
-	message := bytes.NewBuffer(nil)
+func (b *Bot) handleMilestoneReferences(message *reply, channelConfig config.ChannelConfig, userId string, text string) error {
+	for _, match := range MILESTONE_RE.FindAllStringSubmatch(text, -1) {
+		name := match[2]
 
-	for _, match := range matches {
-		tracId := match[1]
-		ticketNumber := match[2]
+		if len(name) == 0 {
+			name = strings.TrimRight(match[3], ".,;")
+		}
 
-		ticket, err := b.handleTicketRequest(channelConfig, tracId, ticketNumber)
+		milestone, err := b.handleMilestoneRequest(channelConfig, userId, match[1], name)
 
 		if err != nil {
 			err = formatErrorMessage(message, err)
 		} else {
-			err = formatTicketMessage(message, b.ticketTemplate, ticket)
+			err = formatMilestoneMessage(message, b.milestoneTemplate, milestone)
 		}
 
 		if err != nil {
-			return errors.Wrap(err, "Error while formatting ticket data")
+			return errors.Wrap(err, "Error while formatting milestone")
 		}
 
 		message.WriteString("\n")
 	}
 
-	reply := model.Post{}
-	reply.ChannelId = post.ChannelId
-	reply.Message = message.String()
+	return nil
+}
+
+func (b *Bot) handleReportReferences(message *reply, channelConfig config.ChannelConfig, userId string, text string) error {
+	for _, match := range REPORT_RE.FindAllStringSubmatch(text, -1) {
+		reportId := match[2] + match[3]
+
+		report, hidden, err := b.handleReportRequest(channelConfig, userId, match[1], reportId)
+
+		if err != nil {
+			err = formatErrorMessage(message, err)
+		} else if err = formatReportMessage(message, b.reportTemplate, report); err == nil {
+			formatHiddenTickets(message, hidden)
+		}
+
+		if err != nil {
+			return errors.Wrap(err, "Error while formatting report")
+		}
 
-	if _, err := b.client.CreatePost(&reply); err != nil {
-		return errors.Wrapf(err, "Error while sending message on channel %s", channelName)
+		message.WriteString("\n")
 	}
 
 	return nil
@@ -271,40 +655,290 @@ func formatErrorMessage(w io.Writer, err error) error {
 	return nil
 }
 
-func formatTicketMessage(w io.Writer, tmpl *template.Template, t trac.Ticket) error {
-	return errors.Wrap(tmpl.Execute(w, t), "Error while rendering ticket template")
+// ticketTemplateData returns the data passed to the ticket template: the
+// ticket fields, along with the maxChanges latest entries of its changelog.
+func ticketTemplateData(t trac.Ticket, changes []trac.TicketChange, maxChanges int) map[string]interface{} {
+	data := make(map[string]interface{}, len(t)+3)
+
+	for field, value := range t {
+		data[field] = value
+	}
+
+	data["LastChange"] = (*trac.TicketChange)(nil)
+	data["LastComment"] = trac.LastComment(changes)
+
+	if len(changes) > 0 {
+		data["LastChange"] = &changes[len(changes)-1]
+	}
+
+	if len(changes) > maxChanges {
+		changes = changes[len(changes)-maxChanges:]
+	}
+
+	data["Changes"] = changes
+
+	return data
+}
+
+func formatTicketMessage(w io.Writer, tmpl *template.Template, t trac.Ticket, changes []trac.TicketChange, maxChanges int) error {
+	return errors.Wrap(tmpl.Execute(w, ticketTemplateData(t, changes, maxChanges)), "Error while rendering ticket template")
+}
+
+// queryMessage is the data passed to the query template
+type queryMessage struct {
+	trac.QueryResult
+	Query string
+}
+
+func formatQueryMessage(w io.Writer, tmpl *template.Template, query string, result trac.QueryResult) error {
+	return errors.Wrap(tmpl.Execute(w, queryMessage{result, query}), "Error while rendering query template")
 }
 
-func (b *Bot) handleTicketRequest(channelConfig config.ChannelConfig, tracId string, ticketNumber string) (trac.Ticket, error) {
+func formatWikiMessage(w io.Writer, tmpl *template.Template, page trac.WikiPage) error {
+	return errors.Wrap(tmpl.Execute(w, page), "Error while rendering wiki template")
+}
+
+func formatChangesetMessage(w io.Writer, tmpl *template.Template, changeset trac.Changeset) error {
+	return errors.Wrap(tmpl.Execute(w, changeset), "Error while rendering changeset template")
+}
+
+func formatMilestoneMessage(w io.Writer, tmpl *template.Template, milestone trac.Milestone) error {
+	return errors.Wrap(tmpl.Execute(w, milestone), "Error while rendering milestone template")
+}
+
+func formatReportMessage(w io.Writer, tmpl *template.Template, report trac.ReportResult) error {
+	return errors.Wrap(tmpl.Execute(w, report), "Error while rendering report template")
+}
+
+// ticketRef is a ticket reference found in a message, tracId being empty if
+// the reference did not specify any Trac instance.
+type ticketRef struct {
+	tracId       string
+	ticketNumber string
+}
+
+// trac returns the Trac instance of the reference, falling back to the
+// default instance of the channel
+func (r ticketRef) trac(channelConfig config.ChannelConfig) string {
+	if len(r.tracId) == 0 {
+		return channelConfig.DefaultTracInstance
+	}
+
+	return r.tracId
+}
+
+// resolveTrac returns the Trac instance to query for a reference to object
+// found in a message posted by userId in a channel. tracId can be empty if the
+// reference did not specify any Trac instance. The returned client uses the
+// credentials registered by the user if any, the shared account otherwise.
+func (b *Bot) resolveTrac(channelConfig config.ChannelConfig, userId string, tracId string, object string) (string, *trac.Client, error) {
 	if len(tracId) == 0 {
 		if len(channelConfig.DefaultTracInstance) > 0 {
 			tracId = channelConfig.DefaultTracInstance
 		} else {
-			return trac.Ticket{}, errors.Errorf("Missing Trac ID for ticket #%s", ticketNumber)
+			return "", nil, errors.Errorf("Missing Trac ID for %s", object)
 		}
 	}
 
 	if !stringSliceContainsNC(channelConfig.TracInstances, tracId) {
-		return trac.Ticket{}, errors.Errorf("Trac ID %s not configured for this channel", tracId)
+		return "", nil, errors.Errorf("Trac ID %s not configured for this channel", tracId)
 	}
 
 	client := b.tracs[strings.ToLower(tracId)]
 
 	if client == nil {
-		return trac.Ticket{}, errors.Errorf("Unknown Trac ID: %s", tracId)
+		return "", nil, errors.Errorf("Unknown Trac ID: %s", tracId)
+	}
+
+	userClient, err := b.userClient(userId, tracId)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	if userClient != nil {
+		client = userClient
+	}
+
+	return tracId, client, nil
+}
+
+func (b *Bot) handleTicketRequest(channelConfig config.ChannelConfig, userId string, tracId string, ticketNumber string) (trac.Ticket, error) {
+	tickets, errs := b.handleTicketRequests(channelConfig, userId, []ticketRef{{tracId, ticketNumber}})
+
+	return tickets[0], errs[0]
+}
+
+// handleTicketRequests retrieves the tickets referenced by refs, issuing a
+// single request per Trac instance. The returned slices have the same length
+// as refs.
+func (b *Bot) handleTicketRequests(channelConfig config.ChannelConfig, userId string, refs []ticketRef) ([]trac.Ticket, []error) {
+	tickets := make([]trac.Ticket, len(refs))
+	errs := make([]error, len(refs))
+	tracIds := make([]string, len(refs))
+
+	// Maps Trac clients to the indices of the refs they should retrieve
+	batches := map[*trac.Client][]int{}
+	var clients []*trac.Client
+
+	for i, ref := range refs {
+		tracId, client, err := b.resolveTrac(channelConfig, userId, ref.tracId, "ticket #"+ref.ticketNumber)
+
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		if _, ok := batches[client]; !ok {
+			clients = append(clients, client)
+		}
+
+		tracIds[i] = tracId
+		batches[client] = append(batches[client], i)
+	}
+
+	for _, client := range clients {
+		indices := batches[client]
+		ids := make([]string, len(indices))
+
+		for j, i := range indices {
+			ids[j] = refs[i].ticketNumber
+		}
+
+		batchTickets, batchErrs := client.GetTickets(ids)
+
+		for j, i := range indices {
+			if batchErrs[j] != nil {
+				errs[i] = errors.Wrapf(batchErrs[j], "Error while retrieving ticket %s#%s", tracIds[i], refs[i].ticketNumber)
+			} else {
+				tickets[i] = batchTickets[j]
+			}
+		}
+	}
+
+	return tickets, errs
+}
+
+// ticketChanges returns the changelog of a ticket if TicketChanges is set.
+// Errors are only logged, since the ticket can be displayed without its
+// changelog.
+func (b *Bot) ticketChanges(channelConfig config.ChannelConfig, userId string, ref ticketRef) []trac.TicketChange {
+	if b.conf.TicketChanges == 0 {
+		return nil
+	}
+
+	tracId, client, err := b.resolveTrac(channelConfig, userId, ref.tracId, "ticket #"+ref.ticketNumber)
+
+	if err != nil {
+		return nil
+	}
+
+	changes, err := client.GetTicketChangelog(ref.ticketNumber)
+
+	if err != nil {
+		log.Printf("Error while retrieving changelog of ticket %s#%s: %s", tracId, ref.ticketNumber, err)
+		return nil
+	}
+
+	return changes
+}
+
+// handleQueryRequest runs a query, leaving out the tickets that cannot be
+// shown in the channel. It also returns the number of left out tickets.
+func (b *Bot) handleQueryRequest(channelConfig config.ChannelConfig, userId string, tracId string, query string) (trac.QueryResult, int, error) {
+	tracId, client, err := b.resolveTrac(channelConfig, userId, tracId, "query "+query)
+
+	if err != nil {
+		return trac.QueryResult{}, 0, err
 	}
 
-	ticket, err := client.GetTicket(ticketNumber)
+	result, err := client.Query(query, b.conf.QueryMaxResults)
 
 	if err != nil {
-		return trac.Ticket{}, errors.Wrapf(err, "Error while retrieving ticket %s#%s", tracId, ticketNumber)
+		return trac.QueryResult{}, 0, errors.Wrapf(err, "Error while running query %s on %s", query, tracId)
 	}
 
-	return ticket, nil
+	var hidden int
+	result.Tickets, hidden = b.publicRows(channelConfig, tracId, result.Tickets)
+
+	return result, hidden, nil
+}
+
+func (b *Bot) handleWikiRequest(channelConfig config.ChannelConfig, userId string, tracId string, pageName string) (trac.WikiPage, error) {
+	tracId, client, err := b.resolveTrac(channelConfig, lookupUser(channelConfig, userId), tracId, "wiki page "+pageName)
+
+	if err != nil {
+		return trac.WikiPage{}, err
+	}
+
+	page, err := client.GetWikiPage(pageName)
+
+	if err != nil {
+		return trac.WikiPage{}, errors.Wrapf(err, "Error while retrieving wiki page %s from %s", pageName, tracId)
+	}
+
+	return page, nil
+}
+
+func (b *Bot) handleChangesetRequest(channelConfig config.ChannelConfig, userId string, tracId string, revision string, repository string) (trac.Changeset, error) {
+	tracId, client, err := b.resolveTrac(channelConfig, lookupUser(channelConfig, userId), tracId, "changeset "+revision)
+
+	if err != nil {
+		return trac.Changeset{}, err
+	}
+
+	changeset, err := client.GetChangeset(revision, repository)
+
+	if err != nil {
+		return trac.Changeset{}, errors.Wrapf(err, "Error while retrieving changeset %s from %s", revision, tracId)
+	}
+
+	return changeset, nil
+}
+
+func (b *Bot) handleMilestoneRequest(channelConfig config.ChannelConfig, userId string, tracId string, name string) (trac.Milestone, error) {
+	tracId, client, err := b.resolveTrac(channelConfig, lookupUser(channelConfig, userId), tracId, "milestone "+name)
+
+	if err != nil {
+		return trac.Milestone{}, err
+	}
+
+	milestone, err := client.GetMilestone(name)
+
+	if err != nil {
+		return trac.Milestone{}, errors.Wrapf(err, "Error while retrieving milestone %s from %s", name, tracId)
+	}
+
+	return milestone, nil
+}
+
+// handleReportRequest runs a report, leaving out the rows whose ticket cannot
+// be shown in the channel. It also returns the number of left out rows.
+func (b *Bot) handleReportRequest(channelConfig config.ChannelConfig, userId string, tracId string, reportId string) (trac.ReportResult, int, error) {
+	tracId, client, err := b.resolveTrac(channelConfig, userId, tracId, "report "+reportId)
+
+	if err != nil {
+		return trac.ReportResult{}, 0, err
+	}
+
+	report, err := client.RunReport(reportId, b.conf.ReportMaxRows)
+
+	if err != nil {
+		return trac.ReportResult{}, 0, errors.Wrapf(err, "Error while running report %s on %s", reportId, tracId)
+	}
+
+	var hidden int
+	report.Rows, hidden = b.publicRows(channelConfig, tracId, report.Rows)
+
+	return report, hidden, nil
 }
 
 func (b *Bot) Close() {
 	b.Lock()
+	close(b.quit)
+	if b.httpServer != nil {
+		b.httpServer.Close()
+	}
 	if b.wsClient != nil {
 		b.wsClient.Close()
 	}
@@ -0,0 +1,256 @@
+package bot
+
+import (
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/mattermost/platform/model"
+	"github.com/pkg/errors"
+
+	"github.com/abustany/mattermost-trac-bot/config"
+)
+
+// TRAC_DIRECTIVE_RE matches the directives selecting the Trac instances of a
+// channel in its header or purpose, eg. trac:trac1 or trac:trac1,trac2
+var TRAC_DIRECTIVE_RE = regexp.MustCompile(`\btrac:([a-zA-Z0-9]+(?:,[a-zA-Z0-9]+)*)`)
+
+// channelRule is a channel rule along with its compiled regular expression
+type channelRule struct {
+	config.ChannelRule
+
+	nameRe *regexp.Regexp
+}
+
+func compileChannelRules(rules []config.ChannelRule) ([]channelRule, error) {
+	compiled := make([]channelRule, len(rules))
+
+	for i, rule := range rules {
+		compiled[i].ChannelRule = rule
+
+		if len(rule.NameRegexp) == 0 {
+			continue
+		}
+
+		re, err := regexp.Compile(rule.NameRegexp)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error while compiling name_regexp of channel rule %d", i+1)
+		}
+
+		compiled[i].nameRe = re
+	}
+
+	return compiled, nil
+}
+
+// directiveTracs returns the Trac IDs listed by the trac: directives of texts
+func directiveTracs(texts ...string) []string {
+	var ids []string
+
+	for _, text := range texts {
+		for _, match := range TRAC_DIRECTIVE_RE.FindAllStringSubmatch(text, -1) {
+			ids = append(ids, strings.Split(match[1], ",")...)
+		}
+	}
+
+	return ids
+}
+
+// match returns the settings given by the rule to a channel, if it matches
+func (r channelRule) match(channel *model.Channel) (config.ChannelConfig, bool) {
+	switch {
+	case r.nameRe != nil:
+		return r.ChannelConfig, r.nameRe.MatchString(channel.Name)
+	case len(r.NameGlob) > 0:
+		matched, _ := path.Match(r.NameGlob, channel.Name)
+		return r.ChannelConfig, matched
+	}
+
+	// Directives can only narrow the Trac instances of the rule
+	var tracs []string
+
+	for _, id := range directiveTracs(channel.Header, channel.Purpose) {
+		for _, allowed := range r.TracInstances {
+			if strings.EqualFold(id, allowed) && !stringSliceContainsNC(tracs, allowed) {
+				tracs = append(tracs, allowed)
+			}
+		}
+	}
+
+	if len(tracs) == 0 {
+		return config.ChannelConfig{}, false
+	}
+
+	channelConfig := r.ChannelConfig
+	channelConfig.TracInstances = tracs
+
+	if !stringSliceContainsNC(tracs, channelConfig.DefaultTracInstance) {
+		channelConfig.DefaultTracInstance = tracs[0]
+	}
+
+	return channelConfig, true
+}
+
+// resolveChannel returns the settings of a channel: those listed under its
+// name in the configuration, or else those of the first matching rule
+func (b *Bot) resolveChannel(channel *model.Channel) (config.ChannelConfig, bool) {
+	if channelConfig, ok := b.conf.Channels[channel.Name]; ok {
+		return channelConfig, true
+	}
+
+	for _, rule := range b.channelRules {
+		if channelConfig, ok := rule.match(channel); ok {
+			return channelConfig, true
+		}
+	}
+
+	return config.ChannelConfig{}, false
+}
+
+// channelConfig returns the settings of a channel given its name. Those of the
+// channels the bot is not a member of are resolved from their name alone.
+func (b *Bot) channelConfig(name string) (config.ChannelConfig, bool) {
+	b.Lock()
+	channelConfig, ok := b.channelConfigs[name]
+	b.Unlock()
+
+	if ok {
+		return channelConfig, true
+	}
+
+	return b.resolveChannel(&model.Channel{Name: name})
+}
+
+// channelId returns the ID of a channel served by the bot, or an empty string
+func (b *Bot) channelId(name string) string {
+	b.Lock()
+	defer b.Unlock()
+
+	if channel := b.channels[name]; channel != nil {
+		return channel.Id
+	}
+
+	return ""
+}
+
+// channelName returns the name of a channel served by the bot, or an empty
+// string
+func (b *Bot) channelName(channelId string) string {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.channelNames[channelId]
+}
+
+// serveChannel starts serving a channel the bot is a member of, or stops if
+// the channel is no longer configured
+func (b *Bot) serveChannel(channel *model.Channel) {
+	channelConfig, ok := b.resolveChannel(channel)
+
+	if !ok {
+		b.removeChannel(channel.Id)
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if name, ok := b.channelNames[channel.Id]; !ok {
+		log.Printf("Serving channel %s", channel.Name)
+	} else if name != channel.Name {
+		// Renamed
+		delete(b.channels, name)
+		delete(b.channelConfigs, name)
+	}
+
+	b.channels[channel.Name] = channel
+	b.channelNames[channel.Id] = channel.Name
+	b.channelConfigs[channel.Name] = channelConfig
+}
+
+// removeChannel stops serving a channel
+func (b *Bot) removeChannel(channelId string) {
+	b.Lock()
+	defer b.Unlock()
+
+	name, ok := b.channelNames[channelId]
+
+	if !ok {
+		return
+	}
+
+	log.Printf("No longer serving channel %s", name)
+
+	delete(b.channels, name)
+	delete(b.channelNames, channelId)
+	delete(b.channelConfigs, name)
+}
+
+// refreshChannel reloads a channel after its settings or the membership of the
+// bot changed
+func (b *Bot) refreshChannel(channelId string) error {
+	res, err := b.client.GetChannel(channelId, "")
+
+	if err != nil {
+		// The bot is not a member of the channel, or it is gone
+		if err.StatusCode == http.StatusForbidden || err.StatusCode == http.StatusNotFound {
+			b.removeChannel(channelId)
+			return nil
+		}
+
+		return errors.Wrapf(err, "Error while retrieving channel %s", channelId)
+	}
+
+	data := res.Data.(*model.ChannelData)
+
+	if data.Member == nil || data.Channel.DeleteAt != 0 {
+		b.removeChannel(channelId)
+		return nil
+	}
+
+	if data.Channel.IsGroupOrDirect() {
+		b.addDirectChannel(data.Channel.Id, data.Channel.Type)
+		return nil
+	}
+
+	b.serveChannel(data.Channel)
+
+	return nil
+}
+
+// handleChannelEvent follows the channels the bot joins or leaves, and the
+// changes of their names, headers and purposes
+func (b *Bot) handleChannelEvent(ev *model.WebSocketEvent) error {
+	channelId := ev.Broadcast.ChannelId
+
+	if id, _ := ev.Data["channel_id"].(string); len(id) > 0 {
+		channelId = id
+	}
+
+	// Events sent to the removed user only identify them in the broadcast
+	userId, _ := ev.Data["user_id"].(string)
+
+	if len(userId) == 0 {
+		userId = ev.Broadcast.UserId
+	}
+
+	switch ev.Event {
+	case model.WEBSOCKET_EVENT_USER_ADDED:
+		if userId == b.botUser().Id {
+			return b.refreshChannel(channelId)
+		}
+	case model.WEBSOCKET_EVENT_USER_REMOVED:
+		if userId == b.botUser().Id {
+			b.removeChannel(channelId)
+		}
+	case model.WEBSOCKET_EVENT_CHANNEL_CREATED, model.WEBSOCKET_EVENT_CHANNEL_UPDATED:
+		return b.refreshChannel(channelId)
+	case model.WEBSOCKET_EVENT_CHANNEL_DELETED:
+		b.removeChannel(channelId)
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package bot
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mattermost/platform/model"
+
+	"github.com/abustany/mattermost-trac-bot/config"
+)
+
+func TestDirectiveTracs(t *testing.T) {
+	ids := directiveTracs("Release planning, trac:trac1,Trac2", "See mytrac:wiki:Foo and trac:trac3")
+	expected := []string{"trac1", "Trac2", "trac3"}
+
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("Unexpected directive Trac IDs: %v, expected %v", ids, expected)
+	}
+}
+
+func testRulesBot(t *testing.T) *Bot {
+	rules, err := compileChannelRules([]config.ChannelRule{
+		{NameGlob: "support-*", ChannelConfig: config.ChannelConfig{TracInstances: []string{"trac1"}}},
+		{NameRegexp: `^proj-(core|web)$`, ChannelConfig: config.ChannelConfig{TracInstances: []string{"trac2"}}},
+		{HeaderDirective: true, ChannelConfig: config.ChannelConfig{TracInstances: []string{"trac1", "trac2"}, DefaultTracInstance: "trac2"}},
+	})
+
+	if err != nil {
+		t.Fatalf("Error while compiling channel rules: %s", err)
+	}
+
+	return &Bot{
+		conf: config.Config{Channels: map[string]config.ChannelConfig{
+			"support-vip": {TracInstances: []string{"trac2"}, UpdateTickets: true},
+		}},
+		channels:       map[string]*model.Channel{},
+		channelNames:   map[string]string{},
+		channelConfigs: map[string]config.ChannelConfig{},
+		channelRules:   rules,
+	}
+}
+
+func TestResolveChannel(t *testing.T) {
+	b := testRulesBot(t)
+
+	testData := []struct {
+		channel       model.Channel
+		ok            bool
+		tracs         []string
+		defaultTracId string
+	}{
+		{model.Channel{Name: "support-vip"}, true, []string{"trac2"}, ""},
+		{model.Channel{Name: "support-eu"}, true, []string{"trac1"}, ""},
+		{model.Channel{Name: "proj-web"}, true, []string{"trac2"}, ""},
+		{model.Channel{Name: "proj-webapp"}, false, nil, ""},
+		{model.Channel{Name: "random"}, false, nil, ""},
+		{model.Channel{Name: "random", Header: "Tickets: trac:trac1"}, true, []string{"trac1"}, "trac1"},
+		{model.Channel{Name: "random", Purpose: "trac:TRAC2,trac1"}, true, []string{"trac2", "trac1"}, "trac2"},
+		{model.Channel{Name: "random", Header: "trac:secret"}, false, nil, ""},
+	}
+
+	for _, data := range testData {
+		channelConfig, ok := b.resolveChannel(&data.channel)
+
+		if ok != data.ok {
+			t.Errorf("Unexpected match of channel %+v: %v", data.channel, ok)
+			continue
+		}
+
+		if !reflect.DeepEqual(channelConfig.TracInstances, data.tracs) || channelConfig.DefaultTracInstance != data.defaultTracId {
+			t.Errorf("Unexpected settings for channel %+v: %+v", data.channel, channelConfig)
+		}
+	}
+}
+
+func TestServeChannel(t *testing.T) {
+	b := testRulesBot(t)
+
+	b.serveChannel(&model.Channel{Id: "c1", Name: "support-eu"})
+
+	if b.channelId("support-eu") != "c1" {
+		t.Fatalf("Channel support-eu should be served")
+	}
+
+	// Renamed to a name matching no rule
+	b.serveChannel(&model.Channel{Id: "c1", Name: "random"})
+
+	if len(b.channels) != 0 || len(b.channelNames) != 0 || len(b.channelConfigs) != 0 {
+		t.Errorf("Channel should no longer be served: %v %v %v", b.channels, b.channelNames, b.channelConfigs)
+	}
+
+	// Header directive added
+	b.serveChannel(&model.Channel{Id: "c1", Name: "random", Header: "trac:trac1"})
+
+	if channelConfig, ok := b.channelConfig("random"); !ok || !reflect.DeepEqual(channelConfig.TracInstances, []string{"trac1"}) {
+		t.Errorf("Unexpected settings of channel random: %+v", channelConfig)
+	}
+
+	// Unknown channels are resolved by name
+	if channelConfig, ok := b.channelConfig("support-vip"); !ok || !channelConfig.UpdateTickets {
+		t.Errorf("Unexpected settings of channel support-vip: %+v", channelConfig)
+	}
+
+	b.removeChannel("c1")
+
+	if len(b.channelId("random")) > 0 {
+		t.Errorf("Channel random should no longer be served")
+	}
+}
+
+func TestConcurrentChannelChanges(t *testing.T) {
+	b := testRulesBot(t)
+	done := make(chan struct{})
+
+	// Channel events and reconnections update the channels while posts are
+	// being answered
+	go func() {
+		for i := 0; i < 100; i++ {
+			b.serveChannel(&model.Channel{Id: "c1", Name: "support-eu"})
+			b.removeChannel("c1")
+		}
+
+		close(done)
+	}()
+
+	for i := 0; i < 100; i++ {
+		if name := b.channelName("c1"); name != "" && name != "support-eu" {
+			t.Errorf("Unexpected channel name: %s", name)
+		}
+	}
+
+	<-done
+}
@@ -0,0 +1,354 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/mattermost/platform/model"
+	"github.com/pkg/errors"
+
+	"github.com/abustany/mattermost-trac-bot/config"
+	"github.com/abustany/mattermost-trac-bot/trac"
+)
+
+// commandToken is a word of a command line. Quoted tokens are never
+// interpreted as field=value assignments.
+type commandToken struct {
+	text   string
+	quoted bool
+}
+
+// splitCommandLine splits a command line into words, handling single and
+// double quotes the way a shell would.
+func splitCommandLine(line string) ([]commandToken, error) {
+	var tokens []commandToken
+	var current bytes.Buffer
+	var quote rune
+	inToken, quoted := false, false
+
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			current.WriteRune(r)
+		case r == '"' || r == '\'':
+			if !inToken {
+				quoted = true
+			}
+
+			quote, inToken = r, true
+		case unicode.IsSpace(r):
+			if inToken {
+				tokens = append(tokens, commandToken{current.String(), quoted})
+				current.Reset()
+				inToken, quoted = false, false
+			}
+		default:
+			current.WriteRune(r)
+			inToken = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.Errorf("Unterminated %c quote", quote)
+	}
+
+	if inToken {
+		tokens = append(tokens, commandToken{current.String(), quoted})
+	}
+
+	return tokens, nil
+}
+
+// commandLine returns the command addressed to the bot in a message, or an
+// empty string if the message does not start by mentioning the bot, as in
+// "@tracbot new ...".
+func (b *Bot) commandLine(text string) string {
+	text = strings.TrimSpace(text)
+	mention := "@" + strings.ToLower(b.botUser().Username)
+
+	if !strings.HasPrefix(strings.ToLower(text), mention) {
+		return ""
+	}
+
+	text = strings.TrimLeft(text[len(mention):], ":,")
+
+	// The mention must be followed by a separator, @tracbot2 is someone else
+	if len(text) > 0 && !unicode.IsSpace(rune(text[0])) {
+		return ""
+	}
+
+	return strings.TrimSpace(text)
+}
+
+// newTicketCommand holds the arguments of the "new" command:
+// new [trac ID] [type] "summary" [field=value...]
+type newTicketCommand struct {
+	tracId     string
+	summary    string
+	attributes map[string]string
+}
+
+const newTicketUsage = `Usage: new [trac ID] [type] "summary" [field=value...]`
+
+// parseNewTicketCommand parses the arguments of the "new" command. isTracId
+// tells whether a word is the ID of a configured Trac instance.
+func parseNewTicketCommand(args []commandToken, isTracId func(string) bool) (newTicketCommand, error) {
+	cmd := newTicketCommand{attributes: map[string]string{}}
+	var positional []string
+
+	for _, arg := range args {
+		if idx := strings.Index(arg.text, "="); !arg.quoted && idx > 0 {
+			cmd.attributes[arg.text[0:idx]] = arg.text[idx+1:]
+		} else {
+			positional = append(positional, arg.text)
+		}
+	}
+
+	if len(positional) > 1 && isTracId(positional[0]) {
+		cmd.tracId, positional = positional[0], positional[1:]
+	}
+
+	switch len(positional) {
+	case 1:
+		cmd.summary = positional[0]
+	case 2:
+		cmd.attributes["type"], cmd.summary = positional[0], positional[1]
+	default:
+		return newTicketCommand{}, errors.New(newTicketUsage)
+	}
+
+	if len(strings.TrimSpace(cmd.summary)) == 0 {
+		return newTicketCommand{}, errors.New("Ticket summary should not be empty")
+	}
+
+	return cmd, nil
+}
+
+// handleCommand runs the command addressed to the bot in post, if any, and
+// writes its outcome to message. It returns false if the post holds no
+// command, in which case it should be scanned for Trac references.
+func (b *Bot) handleCommand(message *reply, channelConfig config.ChannelConfig, post *model.Post) (bool, error) {
+	line := b.commandLine(post.Message)
+	fields := strings.Fields(line)
+
+	if len(fields) == 0 {
+		return false, nil
+	}
+
+	// Arguments are parsed by each command, comments are kept verbatim
+	args := strings.TrimSpace(line[len(fields[0]):])
+	var err error
+
+	switch strings.ToLower(fields[0]) {
+	case "new":
+		err = b.handleNewTicketCommand(message, channelConfig, post, args)
+	case "comment":
+		err = b.handleCommentCommand(message, channelConfig, post, args)
+	case "set":
+		err = b.handleSetCommand(message, channelConfig, post, args)
+	default:
+		// Probably just a message for the bot
+		return false, nil
+	}
+
+	if err != nil {
+		err = formatErrorMessage(message, err)
+	}
+
+	return true, err
+}
+
+// username returns the Mattermost username of a user, for attributing the
+// changes made on their behalf
+func (b *Bot) username(userId string) (string, error) {
+	res, err := b.client.GetUser(userId, "")
+
+	if err != nil {
+		return "", errors.Wrapf(err, "Error while retrieving user %s", userId)
+	}
+
+	return res.Data.(*model.User).Username, nil
+}
+
+// attribute appends to text a mention of the Mattermost user on whose behalf
+// the bot acted, since Trac only knows about the bot account
+func attribute(text, action, author string) string {
+	if len(text) > 0 {
+		text += "\n\n"
+	}
+
+	return text + fmt.Sprintf("%s by @%s via Mattermost", action, author)
+}
+
+func (b *Bot) handleNewTicketCommand(message *reply, channelConfig config.ChannelConfig, post *model.Post, line string) error {
+	if !channelConfig.CreateTickets {
+		return errors.New("Creating tickets is not allowed from this channel")
+	}
+
+	args, err := splitCommandLine(line)
+
+	if err != nil {
+		return err
+	}
+
+	cmd, err := parseNewTicketCommand(args, func(s string) bool {
+		_, ok := b.tracs[strings.ToLower(s)]
+		return ok
+	})
+
+	if err != nil {
+		return err
+	}
+
+	tracId, client, err := b.resolveTrac(channelConfig, post.UserId, cmd.tracId, "new ticket")
+
+	if err != nil {
+		return err
+	}
+
+	author, err := b.username(post.UserId)
+
+	if err != nil {
+		return err
+	}
+
+	description := attribute(cmd.attributes["description"], "Reported", author)
+	delete(cmd.attributes, "description")
+
+	id, err := client.CreateTicket(cmd.summary, description, cmd.attributes)
+
+	if err != nil {
+		return errors.Wrapf(err, "Error while creating ticket on %s", tracId)
+	}
+
+	ticket, err := b.handleTicketRequest(channelConfig, post.UserId, tracId, id)
+
+	if err != nil {
+		return errors.Wrapf(err, "Ticket %s#%s was created, but could not be retrieved", tracId, id)
+	}
+
+	return b.formatVisibleTicket(message, channelConfig, post.UserId, ticketRef{tracId, id}, ticket, nil, 0)
+}
+
+// parseTicketRef parses a ticket reference given as a command argument, such
+// as #35 or trac1#35
+func parseTicketRef(s string) (ticketRef, error) {
+	match := TICKET_RE.FindStringSubmatch(s)
+
+	if match == nil || match[0] != s {
+		return ticketRef{}, errors.Errorf("Invalid ticket reference: %s", s)
+	}
+
+	return ticketRef{tracId: match[1], ticketNumber: match[2]}, nil
+}
+
+// parseSetCommand parses the arguments of the "set" command:
+// set #35 field=value... [comment="text"]
+func parseSetCommand(args []commandToken) (ticketRef, string, map[string]string, error) {
+	const usage = `Usage: set #ticket field=value... [comment="text"]`
+
+	if len(args) < 2 {
+		return ticketRef{}, "", nil, errors.New(usage)
+	}
+
+	ref, err := parseTicketRef(args[0].text)
+
+	if err != nil {
+		return ticketRef{}, "", nil, err
+	}
+
+	attributes := map[string]string{}
+
+	for _, arg := range args[1:] {
+		idx := strings.Index(arg.text, "=")
+
+		if arg.quoted || idx <= 0 {
+			return ticketRef{}, "", nil, errors.New(usage)
+		}
+
+		attributes[arg.text[0:idx]] = arg.text[idx+1:]
+	}
+
+	comment := attributes["comment"]
+	delete(attributes, "comment")
+
+	return ref, comment, attributes, nil
+}
+
+func (b *Bot) handleCommentCommand(message *reply, channelConfig config.ChannelConfig, post *model.Post, line string) error {
+	fields := strings.Fields(line)
+
+	if len(fields) < 2 {
+		return errors.New("Usage: comment #ticket text")
+	}
+
+	ref, err := parseTicketRef(fields[0])
+
+	if err != nil {
+		return err
+	}
+
+	comment := strings.TrimSpace(line[len(fields[0]):])
+
+	return b.updateTicket(message, channelConfig, post, ref, comment, nil, "Posted")
+}
+
+func (b *Bot) handleSetCommand(message *reply, channelConfig config.ChannelConfig, post *model.Post, line string) error {
+	args, err := splitCommandLine(line)
+
+	if err != nil {
+		return err
+	}
+
+	ref, comment, attributes, err := parseSetCommand(args)
+
+	if err != nil {
+		return err
+	}
+
+	return b.updateTicket(message, channelConfig, post, ref, comment, attributes, "Changed")
+}
+
+// updateTicket applies the changes requested by the author of post to a
+// ticket, and writes the updated ticket to message
+func (b *Bot) updateTicket(message *reply, channelConfig config.ChannelConfig, post *model.Post, ref ticketRef, comment string, attributes map[string]string, action string) error {
+	if !channelConfig.UpdateTickets {
+		return errors.New("Updating tickets is not allowed from this channel")
+	}
+
+	tracId, client, err := b.resolveTrac(channelConfig, post.UserId, ref.tracId, "ticket #"+ref.ticketNumber)
+
+	if err != nil {
+		return err
+	}
+
+	author, err := b.username(post.UserId)
+
+	if err != nil {
+		return err
+	}
+
+	err = client.UpdateTicket(ref.ticketNumber, attribute(comment, action, author), attributes)
+
+	if errors.Cause(err) == trac.ErrTicketConflict {
+		return errors.Errorf("Ticket %s#%s was modified by someone else in the meantime, please try again", tracId, ref.ticketNumber)
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "Error while updating ticket %s#%s", tracId, ref.ticketNumber)
+	}
+
+	ticket, err := b.handleTicketRequest(channelConfig, post.UserId, tracId, ref.ticketNumber)
+
+	if err != nil {
+		return err
+	}
+
+	ref.tracId = tracId
+
+	return b.formatVisibleTicket(message, channelConfig, post.UserId, ref, ticket, b.ticketChanges(channelConfig, post.UserId, ref), b.conf.TicketChanges)
+}
@@ -0,0 +1,121 @@
+package bot
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mattermost/platform/model"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	tokens, err := splitCommandLine(`new  trac1 "Panic in 'backend'" component='core ui' ""`)
+
+	if err != nil {
+		t.Fatalf("splitCommandLine failed: %s", err)
+	}
+
+	expected := []commandToken{
+		{"new", false},
+		{"trac1", false},
+		{"Panic in 'backend'", true},
+		{"component=core ui", false},
+		{"", true},
+	}
+
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Unexpected tokens: %v", tokens)
+	}
+
+	if _, err := splitCommandLine(`new "Panic`); err == nil {
+		t.Errorf("splitCommandLine should fail on unterminated quotes")
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	b := &Bot{user: &model.User{Username: "tracbot"}}
+
+	for text, expected := range map[string]string{
+		"@tracbot new defect x": "new defect x",
+		"  @TracBot: new":       "new",
+		"@tracbot, new":         "new",
+		"@tracbot2 new":         "",
+		"hey @tracbot new":      "",
+		"@tracbot":              "",
+	} {
+		if line := b.commandLine(text); line != expected {
+			t.Errorf("Unexpected command line for '%s': '%s', expected '%s'", text, line, expected)
+		}
+	}
+}
+
+func TestParseNewTicketCommand(t *testing.T) {
+	isTracId := func(s string) bool { return s == "trac1" }
+
+	for line, expected := range map[string]newTicketCommand{
+		`trac1 defect "Panic in backend" component=core`: {
+			tracId:     "trac1",
+			summary:    "Panic in backend",
+			attributes: map[string]string{"type": "defect", "component": "core"},
+		},
+		`"Panic in backend"`: {
+			summary:    "Panic in backend",
+			attributes: map[string]string{},
+		},
+		`trac1 "a=b is wrong"`: {
+			tracId:     "trac1",
+			summary:    "a=b is wrong",
+			attributes: map[string]string{},
+		},
+		`trac1 "Panic" "in backend"`: {
+			tracId:     "trac1",
+			summary:    "in backend",
+			attributes: map[string]string{"type": "Panic"},
+		},
+	} {
+		args, _ := splitCommandLine(line)
+		cmd, err := parseNewTicketCommand(args, isTracId)
+
+		if err != nil {
+			t.Errorf("Unexpected error for '%s': %s", line, err)
+		} else if !reflect.DeepEqual(cmd, expected) {
+			t.Errorf("Unexpected command for '%s': %v", line, cmd)
+		}
+	}
+
+	for _, line := range []string{``, `component=core`, `trac1 defect "Panic" extra`, `""`} {
+		args, _ := splitCommandLine(line)
+
+		if _, err := parseNewTicketCommand(args, isTracId); err == nil {
+			t.Errorf("parseNewTicketCommand should fail for '%s'", line)
+		}
+	}
+}
+
+func TestParseSetCommand(t *testing.T) {
+	args, _ := splitCommandLine(`trac1#35 status=closed resolution=fixed comment="Fixed in r1200"`)
+	ref, comment, attributes, err := parseSetCommand(args)
+
+	if err != nil {
+		t.Fatalf("parseSetCommand failed: %s", err)
+	}
+
+	if ref != (ticketRef{tracId: "trac1", ticketNumber: "35"}) {
+		t.Errorf("Unexpected ticket reference: %v", ref)
+	}
+
+	if comment != "Fixed in r1200" {
+		t.Errorf("Unexpected comment: %s", comment)
+	}
+
+	if !reflect.DeepEqual(attributes, map[string]string{"status": "closed", "resolution": "fixed"}) {
+		t.Errorf("Unexpected attributes: %v", attributes)
+	}
+
+	for _, line := range []string{`#35`, `35 status=closed`, `#35x status=closed`, `#35 closed`} {
+		args, _ := splitCommandLine(line)
+
+		if _, _, _, err := parseSetCommand(args); err == nil {
+			t.Errorf("parseSetCommand should fail for '%s'", line)
+		}
+	}
+}
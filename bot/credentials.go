@@ -0,0 +1,260 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/mattermost/platform/model"
+	"github.com/pkg/errors"
+
+	"github.com/abustany/mattermost-trac-bot/config"
+	"github.com/abustany/mattermost-trac-bot/credentials"
+	"github.com/abustany/mattermost-trac-bot/trac"
+)
+
+// userClient returns a client for a Trac instance authenticated with the
+// credentials registered by a Mattermost user, or nil if the user registered
+// none. Clients are created on first use and then reused.
+func (b *Bot) userClient(userId string, tracId string) (*trac.Client, error) {
+	if b.credentials == nil || len(userId) == 0 {
+		return nil, nil
+	}
+
+	id := strings.ToLower(tracId)
+
+	b.userTracsLock.Lock()
+	client := b.userTracs[userId][id]
+	b.userTracsLock.Unlock()
+
+	if client != nil {
+		return client, nil
+	}
+
+	credential, ok := b.credentials.Get(userId, id)
+
+	if !ok {
+		return nil, nil
+	}
+
+	// Authenticating can take a while, the other users should not wait
+	client, err := newTracClient(b.conf.Tracs[makeTracIds(b.conf.Tracs)[id]], credential.Username, credential.Password, b.debug)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Your credentials for %s were rejected, please register them again", tracId)
+	}
+
+	b.userTracsLock.Lock()
+	defer b.userTracsLock.Unlock()
+
+	// Another request of the user may have created a client in the meantime
+	if existing := b.userTracs[userId][id]; existing != nil {
+		return existing, nil
+	}
+
+	b.setUserClient(userId, id, client)
+
+	return client, nil
+}
+
+// sharedChannelConfig returns the settings with which the messages of a user
+// are answered in a channel shared with other users. The non-public tickets
+// are redacted if the user registered personal Trac accounts, since they may
+// see tickets the other members of the channel are not allowed to see.
+func (b *Bot) sharedChannelConfig(channelConfig config.ChannelConfig, userId string) config.ChannelConfig {
+	if b.credentials == nil || restrictsTickets(channelConfig) || len(b.credentials.TracIds(userId)) == 0 {
+		return channelConfig
+	}
+
+	channelConfig.RestrictedTickets = config.RestrictedTicketsRedact
+
+	return channelConfig
+}
+
+// lookupUser returns the user whose personal Trac accounts may be used to look
+// up the objects that cannot be redacted, such as wiki pages or changesets:
+// none in the channels restricting ticket details, where the shared accounts
+// are used so that only what every member may see is shown.
+func lookupUser(channelConfig config.ChannelConfig, userId string) string {
+	if restrictsTickets(channelConfig) {
+		return ""
+	}
+
+	return userId
+}
+
+// setUserClient replaces the client of a user for a Trac instance, client
+// being nil to remove it. userTracsLock must be held.
+func (b *Bot) setUserClient(userId string, id string, client *trac.Client) {
+	if client == nil {
+		delete(b.userTracs[userId], id)
+		return
+	}
+
+	if b.userTracs[userId] == nil {
+		b.userTracs[userId] = map[string]*trac.Client{}
+	}
+
+	b.userTracs[userId][id] = client
+}
+
+const credentialsUsage = "Send me `login <trac ID> <username> <password>` (the password being the rest of the line) to act on Trac with your own account, `logout <trac ID>` to go back to the shared account, or `accounts` to list your registered accounts."
+
+// handleCredentialsCommand runs the credential management command sent to the
+// bot in a direct message, and writes its outcome to message. It returns false
+// if the post holds no such command.
+func (b *Bot) handleCredentialsCommand(message *bytes.Buffer, post *model.Post) bool {
+	fields := strings.Fields(post.Message)
+
+	if len(fields) == 0 {
+		return false
+	}
+
+	command := strings.ToLower(fields[0])
+
+	switch command {
+	case "login", "logout", "accounts":
+	default:
+		return false
+	}
+
+	if b.credentials == nil {
+		message.WriteString("Personal Trac accounts are not enabled on this bot.")
+		return true
+	}
+
+	var err error
+
+	switch command {
+	case "login":
+		err = b.handleLoginCommand(message, post.UserId, post.Message)
+	case "logout":
+		err = b.handleLogoutCommand(message, post.UserId, fields[1:])
+	case "accounts":
+		b.handleAccountsCommand(message, post.UserId)
+	}
+
+	if err != nil {
+		formatErrorMessage(message, err)
+	}
+
+	return true
+}
+
+// configuredTracId returns the normalized form of tracId, or an error if no
+// such Trac instance is configured
+func (b *Bot) configuredTracId(tracId string) (string, error) {
+	id := strings.ToLower(tracId)
+
+	if _, ok := b.tracs[id]; !ok {
+		return "", errors.Errorf("Unknown Trac ID: %s", tracId)
+	}
+
+	return id, nil
+}
+
+// cutFields splits the first n whitespace separated fields of the first line
+// of text from the rest of that line
+func cutFields(text string, n int) ([]string, string) {
+	line := strings.TrimRight(strings.SplitN(text, "\n", 2)[0], "\r")
+	var fields []string
+
+	for len(fields) < n {
+		line = strings.TrimLeftFunc(line, unicode.IsSpace)
+
+		if len(line) == 0 {
+			break
+		}
+
+		end := strings.IndexFunc(line, unicode.IsSpace)
+
+		if end < 0 {
+			end = len(line)
+		}
+
+		fields = append(fields, line[:end])
+		line = line[end:]
+	}
+
+	return fields, strings.TrimLeftFunc(line, unicode.IsSpace)
+}
+
+func (b *Bot) handleLoginCommand(message *bytes.Buffer, userId string, text string) error {
+	// Passwords may hold spaces
+	args, password := cutFields(text, 3)
+
+	if len(args) != 3 || len(password) == 0 {
+		return errors.New("Usage: login <trac ID> <username> <password>")
+	}
+
+	id, err := b.configuredTracId(args[1])
+
+	if err != nil {
+		return err
+	}
+
+	credential := credentials.Credential{Username: args[2], Password: password}
+
+	// Check the credentials before storing them
+	client, err := newTracClient(b.conf.Tracs[makeTracIds(b.conf.Tracs)[id]], credential.Username, credential.Password, b.debug)
+
+	if err != nil {
+		return errors.Wrapf(err, "Cannot log in to %s", args[1])
+	}
+
+	if err := b.credentials.Set(userId, id, credential); err != nil {
+		return errors.Wrap(err, "Error while saving your credentials")
+	}
+
+	b.userTracsLock.Lock()
+	b.setUserClient(userId, id, client)
+	b.userTracsLock.Unlock()
+
+	fmt.Fprintf(message, "You are now logged in to %s as %s. You may want to delete your message holding the password.", args[1], credential.Username)
+
+	return nil
+}
+
+func (b *Bot) handleLogoutCommand(message *bytes.Buffer, userId string, args []string) error {
+	if len(args) != 1 {
+		return errors.New("Usage: logout <trac ID>")
+	}
+
+	id, err := b.configuredTracId(args[0])
+
+	if err != nil {
+		return err
+	}
+
+	if err := b.credentials.Remove(userId, id); err != nil {
+		return errors.Wrap(err, "Error while removing your credentials")
+	}
+
+	b.userTracsLock.Lock()
+	b.setUserClient(userId, id, nil)
+	b.userTracsLock.Unlock()
+
+	fmt.Fprintf(message, "Your credentials for %s were removed, the shared account will be used instead.", args[0])
+
+	return nil
+}
+
+func (b *Bot) handleAccountsCommand(message *bytes.Buffer, userId string) {
+	ids := b.credentials.TracIds(userId)
+
+	if len(ids) == 0 {
+		message.WriteString("You have not registered any Trac account, the shared accounts are used.")
+		return
+	}
+
+	sort.Strings(ids)
+
+	message.WriteString("Your Trac accounts:")
+
+	for _, id := range ids {
+		credential, _ := b.credentials.Get(userId, id)
+		fmt.Fprintf(message, "\n- %s: %s", id, credential.Username)
+	}
+}
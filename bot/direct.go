@@ -0,0 +1,127 @@
+package bot
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/mattermost/platform/model"
+	"github.com/pkg/errors"
+)
+
+// directMessageUsage returns the help sent in reply to the direct messages the
+// bot does not understand. lookups tells whether Trac objects can be looked up
+// in direct messages, and credentials whether users can register their Trac
+// accounts.
+func directMessageUsage(lookups, credentials bool) string {
+	var usage []string
+
+	if lookups {
+		usage = append(usage, "Mention a ticket (#123), a query, a wiki page, a changeset, a milestone or a report and I will show its details.")
+	}
+
+	if credentials {
+		usage = append(usage, credentialsUsage)
+	}
+
+	if len(usage) == 0 {
+		return "I do not answer direct messages, please mention Trac objects in a channel where I am active."
+	}
+
+	return strings.Join(usage, "\n\n")
+}
+
+// DIRECT_CHANNEL_NAME_RE matches the names Mattermost gives to direct message
+// channels, made of the IDs of both users, and to group message channels, a
+// hash of the IDs of their members
+var DIRECT_CHANNEL_NAME_RE = regexp.MustCompile(`^(?:[a-z0-9]{26}__[a-z0-9]{26}|[0-9a-f]{40})$`)
+
+// directChannel tells whether a channel is a direct or group message channel.
+// The bot does not know the channels between other users, which are
+// recognized by their name.
+func (b *Bot) directChannel(channelId string, channelName string) bool {
+	b.Lock()
+	_, ok := b.directChannels[channelId]
+	b.Unlock()
+
+	return ok || DIRECT_CHANNEL_NAME_RE.MatchString(channelName)
+}
+
+// addDirectChannel records a direct or group message channel of the bot,
+// channelType being model.CHANNEL_DIRECT or model.CHANNEL_GROUP
+func (b *Bot) addDirectChannel(channelId string, channelType string) {
+	if len(channelId) == 0 {
+		return
+	}
+
+	b.Lock()
+	b.directChannels[channelId] = channelType
+	b.Unlock()
+}
+
+// handleDirectMessage answers a post of a direct or group message channel. In
+// direct messages, the credential management commands are tried first. Trac
+// objects are then looked up if the direct_messages settings allow it.
+func (b *Bot) handleDirectMessage(post *model.Post) error {
+	b.Lock()
+	group := b.directChannels[post.ChannelId] == model.CHANNEL_GROUP
+	b.Unlock()
+
+	message := &reply{}
+
+	// Passwords have no business in group messages
+	if !group && b.handleCredentialsCommand(&message.Buffer, post) {
+		return b.replyDirectMessage(post, message)
+	}
+
+	if b.conf.DirectMessages != nil {
+		channelConfig := *b.conf.DirectMessages
+
+		if group {
+			channelConfig = b.sharedChannelConfig(channelConfig, post.UserId)
+		}
+
+		handled, err := b.handleCommand(message, channelConfig, post)
+
+		if err != nil {
+			return err
+		} else if !handled {
+			if err := b.handleReferences(message, channelConfig, post.UserId, post.Message); err != nil {
+				return err
+			}
+		}
+
+		message.rootId = replyRootId(channelConfig, post)
+	}
+
+	// Group members talk to each other, only direct messages are meant for
+	// the bot
+	if message.empty() {
+		if group {
+			return nil
+		}
+
+		message.WriteString(directMessageUsage(b.conf.DirectMessages != nil, b.credentials != nil))
+	}
+
+	return b.replyDirectMessage(post, message)
+}
+
+// replyDirectMessage posts a reply in the direct or group message channel of
+// post
+func (b *Bot) replyDirectMessage(post *model.Post, message *reply) error {
+	reply := model.Post{}
+	reply.ChannelId = post.ChannelId
+	reply.Message = message.String()
+	reply.RootId = message.rootId
+	reply.ParentId = message.rootId
+
+	if len(message.attachments) > 0 {
+		reply.AddProp("attachments", message.attachments)
+	}
+
+	if _, err := b.client.CreatePost(&reply); err != nil {
+		return errors.Wrap(err, "Error while sending direct message")
+	}
+
+	return nil
+}
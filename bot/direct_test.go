@@ -0,0 +1,173 @@
+package bot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/platform/model"
+
+	"github.com/abustany/mattermost-trac-bot/config"
+	"github.com/abustany/mattermost-trac-bot/credentials"
+	"github.com/abustany/mattermost-trac-bot/trac"
+)
+
+func TestDirectMessageUsage(t *testing.T) {
+	if usage := directMessageUsage(false, false); strings.Contains(usage, "login") || strings.Contains(usage, "#123") {
+		t.Errorf("Unexpected usage without lookups nor credentials: %s", usage)
+	}
+
+	if usage := directMessageUsage(true, false); !strings.Contains(usage, "#123") || strings.Contains(usage, "login") {
+		t.Errorf("Unexpected usage with lookups: %s", usage)
+	}
+
+	if usage := directMessageUsage(true, true); !strings.Contains(usage, "#123") || !strings.Contains(usage, credentialsUsage) {
+		t.Errorf("Unexpected usage with lookups and credentials: %s", usage)
+	}
+}
+
+func TestCredentialsCommandDisabled(t *testing.T) {
+	b := &Bot{}
+	message := &reply{}
+
+	if b.handleCredentialsCommand(&message.Buffer, &model.Post{Message: "#12 is broken"}) {
+		t.Errorf("A message with no credentials command should not be handled")
+	}
+
+	if !b.handleCredentialsCommand(&message.Buffer, &model.Post{Message: "Login trac1 alice secret"}) {
+		t.Fatalf("The login command should be handled")
+	}
+
+	if !strings.Contains(message.String(), "not enabled") {
+		t.Errorf("Unexpected reply when credentials are disabled: %s", message.String())
+	}
+}
+
+func TestCutFields(t *testing.T) {
+	for _, test := range []struct {
+		text   string
+		fields []string
+		rest   string
+	}{
+		{"login trac1 alice secret", []string{"login", "trac1", "alice"}, "secret"},
+		{"login  trac1 alice  my secret phrase ", []string{"login", "trac1", "alice"}, "my secret phrase "},
+		{"login trac1 alice\nsecret", []string{"login", "trac1", "alice"}, ""},
+		{"login trac1 alice secret\r\nthanks", []string{"login", "trac1", "alice"}, "secret"},
+		{"login trac1", []string{"login", "trac1"}, ""},
+	} {
+		fields, rest := cutFields(test.text, 3)
+
+		if !reflect.DeepEqual(fields, test.fields) || rest != test.rest {
+			t.Errorf("Unexpected fields of %q: %q and %q, expected %q and %q", test.text, fields, rest, test.fields, test.rest)
+		}
+	}
+}
+
+// testCredentials returns a credentials store in which user u1 registered an
+// account for trac1
+func testCredentials(t *testing.T) (*credentials.Store, func()) {
+	dir, err := ioutil.TempDir("", "credentials")
+
+	if err != nil {
+		t.Fatalf("Error while creating temporary directory: %s", err)
+	}
+
+	store, err := credentials.Open(filepath.Join(dir, "credentials"), make([]byte, 32))
+
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Error while opening credentials: %s", err)
+	}
+
+	if err := store.Set("u1", "trac1", credentials.Credential{Username: "alice", Password: "secret"}); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Error while saving credentials: %s", err)
+	}
+
+	return store, func() { os.RemoveAll(dir) }
+}
+
+func TestSharedChannelConfig(t *testing.T) {
+	store, cleanup := testCredentials(t)
+	defer cleanup()
+
+	b := &Bot{credentials: store}
+
+	for _, test := range []struct {
+		userId            string
+		restrictedTickets string
+		expected          string
+	}{
+		{"u1", "", config.RestrictedTicketsRedact},
+		{"u1", config.RestrictedTicketsShow, config.RestrictedTicketsRedact},
+		{"u1", config.RestrictedTicketsDirectMessage, config.RestrictedTicketsDirectMessage},
+		{"u2", config.RestrictedTicketsShow, config.RestrictedTicketsShow},
+	} {
+		channelConfig := b.sharedChannelConfig(config.ChannelConfig{RestrictedTickets: test.restrictedTickets}, test.userId)
+
+		if channelConfig.RestrictedTickets != test.expected {
+			t.Errorf("Unexpected restricted_tickets for %s in a %q channel: %q, expected %q", test.userId, test.restrictedTickets, channelConfig.RestrictedTickets, test.expected)
+		}
+	}
+
+	if channelConfig := (&Bot{}).sharedChannelConfig(config.ChannelConfig{}, "u1"); channelConfig.RestrictedTickets != "" {
+		t.Errorf("Tickets should not be redacted without personal accounts: %q", channelConfig.RestrictedTickets)
+	}
+}
+
+func TestSharedWikiLookup(t *testing.T) {
+	store, cleanup := testCredentials(t)
+	defer cleanup()
+
+	// Only the personal account of u1 can read the page
+	personalServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/wiki/Secret" || r.URL.Query().Get("format") != "txt" {
+			http.NotFound(w, r)
+			return
+		}
+
+		fmt.Fprint(w, "= Secret =\nFor alice only")
+	}))
+
+	defer personalServer.Close()
+
+	sharedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+
+	defer sharedServer.Close()
+
+	personalClient, err := trac.New(personalServer.URL, trac.AuthBasic, false)
+
+	if err != nil {
+		t.Fatalf("Error while creating Trac client: %s", err)
+	}
+
+	sharedClient, err := trac.New(sharedServer.URL, trac.AuthBasic, false)
+
+	if err != nil {
+		t.Fatalf("Error while creating Trac client: %s", err)
+	}
+
+	b := &Bot{
+		credentials: store,
+		tracs:       map[string]*trac.Client{"trac1": sharedClient},
+		userTracs:   map[string]map[string]*trac.Client{"u1": {"trac1": personalClient}},
+	}
+
+	channelConfig := config.ChannelConfig{TracInstances: []string{"trac1"}, DefaultTracInstance: "trac1"}
+
+	if page, err := b.handleWikiRequest(channelConfig, "u1", "", "Secret"); err != nil || page.Text != "= Secret =\nFor alice only" {
+		t.Errorf("The personal account should be used in direct messages, got %+v (%v)", page, err)
+	}
+
+	if page, err := b.handleWikiRequest(b.sharedChannelConfig(channelConfig, "u1"), "u1", "", "Secret"); err == nil {
+		t.Errorf("The shared account should be used in shared channels, got %+v", page)
+	}
+}
@@ -0,0 +1,107 @@
+package bot
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/platform/model"
+	"github.com/pkg/errors"
+)
+
+// Serve answers the requests of the HTTP endpoints until the bot is closed,
+// without logging in to Mattermost. It is used instead of Run in the
+// outgoing_webhook mode.
+func (b *Bot) Serve() error {
+	if err := b.startHTTPServer(); err != nil {
+		return errors.Wrap(err, "Error while starting HTTP server")
+	}
+
+	<-b.quit
+
+	return nil
+}
+
+// parseOutgoingWebhookPayload reads the payload of a Mattermost outgoing
+// webhook, sent either as JSON or as form data depending on the webhook
+// content type
+func parseOutgoingWebhookPayload(r *http.Request) (model.OutgoingWebhookPayload, error) {
+	var payload model.OutgoingWebhookPayload
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		err := json.NewDecoder(r.Body).Decode(&payload)
+		return payload, err
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return payload, err
+	}
+
+	payload.Token = r.PostForm.Get("token")
+	payload.ChannelName = r.PostForm.Get("channel_name")
+	payload.UserId = r.PostForm.Get("user_id")
+	payload.Text = r.PostForm.Get("text")
+
+	return payload, nil
+}
+
+// outgoingWebhookAuthorized checks the token of an outgoing webhook against
+// the configured ones
+func (b *Bot) outgoingWebhookAuthorized(token string) bool {
+	for _, t := range b.conf.OutgoingWebhookTokens {
+		if len(t) > 0 && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handleOutgoingWebhook answers the messages posted by Mattermost outgoing
+// webhooks with the information about the Trac objects they mention. An
+// empty response means that Mattermost posts nothing.
+func (b *Bot) handleOutgoingWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload, err := parseOutgoingWebhookPayload(r)
+
+	if err != nil {
+		http.Error(w, "Invalid payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !b.outgoingWebhookAuthorized(payload.Token) {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	channelConfig, ok := b.channelConfig(payload.ChannelName)
+
+	if !ok {
+		log.Printf("Ignoring outgoing webhook message from unconfigured channel %s", payload.ChannelName)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	message := &reply{}
+
+	if err := b.handleReferences(message, channelConfig, payload.UserId, payload.Text); err != nil {
+		log.Printf("Error while handling outgoing webhook message in %s: %s", payload.ChannelName, err)
+	}
+
+	if message.empty() {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	response := model.CommandResponse{Text: message.String(), Attachments: message.attachments}
+
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, response.ToJson())
+}
@@ -0,0 +1,46 @@
+package bot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/abustany/mattermost-trac-bot/config"
+)
+
+func TestOutgoingWebhook(t *testing.T) {
+	b := &Bot{conf: config.Config{
+		OutgoingWebhookTokens: []string{"hook1", "hook2"},
+		Channels:              map[string]config.ChannelConfig{"town-square": {}},
+	}}
+
+	form := func(token, channel string) string {
+		return url.Values{"token": {token}, "channel_name": {channel}, "text": {"nothing to see"}}.Encode()
+	}
+
+	for _, test := range []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{"application/x-www-form-urlencoded", form("wrong", "town-square"), http.StatusUnauthorized},
+		{"application/x-www-form-urlencoded", form("", "town-square"), http.StatusUnauthorized},
+		{"application/x-www-form-urlencoded", form("hook2", "town-square"), http.StatusNoContent},
+		{"application/x-www-form-urlencoded", form("hook1", "off-topic"), http.StatusNoContent},
+		{"application/json", `{"token": "hook1", "channel_name": "town-square", "text": "hello"}`, http.StatusNoContent},
+		{"application/json", `{"token": "wrong", "channel_name": "town-square"}`, http.StatusUnauthorized},
+		{"application/json", `{`, http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest("POST", "/outgoing", strings.NewReader(test.body))
+		req.Header.Set("Content-Type", test.contentType)
+
+		w := httptest.NewRecorder()
+		b.handleOutgoingWebhook(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("Unexpected status for %s: %d, expected %d", test.body, w.Code, test.status)
+		}
+	}
+}
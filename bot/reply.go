@@ -0,0 +1,185 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mattermost/platform/model"
+	"github.com/pkg/errors"
+
+	"github.com/abustany/mattermost-trac-bot/config"
+)
+
+// REFERENCE_RES lists the regular expressions matching references to Trac
+// objects. Their first group is the Trac ID, empty if the reference does not
+// specify any.
+var REFERENCE_RES = []*regexp.Regexp{TICKET_RE, QUERY_RE, WIKI_RE, CHANGESET_RE, MILESTONE_RE, REPORT_RE}
+
+// CODE_RE matches markdown code blocks and code spans, in which references are
+// not looked for
+var CODE_RE = regexp.MustCompile("(?s)```.*?```|`[^`\n]+`")
+
+// withoutCode returns text with its code blocks and spans blanked out
+func withoutCode(text string) string {
+	return CODE_RE.ReplaceAllLiteralString(text, " ")
+}
+
+// reply is a message composed by the bot: markdown text, followed by
+// attachments
+type reply struct {
+	bytes.Buffer
+	attachments []*model.SlackAttachment
+
+	// Tickets shown as attachments
+	tickets []ticketRef
+
+	// Thread in which the reply is posted, empty to post at the root of the
+	// channel
+	rootId string
+}
+
+// empty returns whether there is nothing to post
+func (r *reply) empty() bool {
+	return len(strings.TrimSpace(r.String())) == 0 && len(r.attachments) == 0
+}
+
+// botWebhookProp marks the posts made by the bot through incoming webhooks
+const botWebhookProp = "from_trac_bot"
+
+// incomingWebhookClient posts the messages sent through incoming webhooks
+var incomingWebhookClient = &http.Client{Timeout: 30 * time.Second}
+
+// replyTrac returns the Trac instance about which a message replying to text
+// is: the one all the references of text point to, or the default instance
+// of the channel if there are none. An empty string is returned if the
+// references point to several instances.
+func replyTrac(channelConfig config.ChannelConfig, text string) string {
+	tracId := ""
+	text = withoutCode(text)
+
+	for _, re := range REFERENCE_RES {
+		for _, match := range re.FindAllStringSubmatch(text, -1) {
+			id := match[1]
+
+			if len(id) == 0 {
+				id = channelConfig.DefaultTracInstance
+			}
+
+			if len(tracId) == 0 {
+				tracId = id
+			} else if strings.ToLower(id) != strings.ToLower(tracId) {
+				return ""
+			}
+		}
+	}
+
+	if len(tracId) == 0 {
+		return channelConfig.DefaultTracInstance
+	}
+
+	return tracId
+}
+
+// replyRootId returns the thread in which to reply to a post, depending on the
+// reply mode of the channel, or an empty string to reply at the root of the
+// channel
+func replyRootId(channelConfig config.ChannelConfig, post *model.Post) string {
+	switch channelConfig.ReplyMode {
+	case config.ReplyModeThread:
+		if len(post.RootId) > 0 {
+			return post.RootId
+		}
+
+		return post.Id
+	case config.ReplyModeSameAsTrigger:
+		return post.RootId
+	}
+
+	return ""
+}
+
+// postMessage posts a message about a Trac instance in a configured channel,
+// through the incoming webhook of the channel if it has one. tracId can be
+// empty if the message is not about a single instance. It returns the ID of
+// the created post, unknown (empty) when posting through a webhook.
+func (b *Bot) postMessage(channelName string, tracId string, message *reply) (string, error) {
+	channelConfig, _ := b.channelConfig(channelName)
+
+	b.addTicketActions(channelName, message)
+
+	if len(channelConfig.IncomingWebhook) > 0 {
+		return "", b.postWithWebhook(channelConfig.IncomingWebhook, channelName, tracId, message)
+	}
+
+	channelId := b.channelId(channelName)
+
+	if len(channelId) == 0 {
+		return "", errors.Errorf("Cannot send message on channel %s, the bot is not a member of it", channelName)
+	}
+
+	post := model.Post{}
+	post.ChannelId = channelId
+	post.Message = message.String()
+	post.RootId = message.rootId
+	post.ParentId = message.rootId
+
+	if len(message.attachments) > 0 {
+		post.AddProp("attachments", message.attachments)
+	}
+
+	res, err := b.client.CreatePost(&post)
+
+	if err != nil {
+		return "", errors.Wrapf(err, "Error while sending message on channel %s", channelName)
+	}
+
+	return res.Data.(*model.Post).Id, nil
+}
+
+// postWithWebhook posts a message through an incoming webhook, using the
+// display name and icon of the Trac instance the message is about
+func (b *Bot) postWithWebhook(url string, channelName string, tracId string, message *reply) error {
+	tracConfig := b.conf.Tracs[makeTracIds(b.conf.Tracs)[strings.ToLower(tracId)]]
+
+	request := model.IncomingWebhookRequest{
+		Text:        message.String(),
+		Attachments: message.attachments,
+		Username:    tracConfig.DisplayName,
+		IconURL:     tracConfig.IconURL,
+		ChannelName: channelName,
+		Props:       model.StringInterface{botWebhookProp: "true"},
+	}
+
+	body, err := json.Marshal(request)
+
+	if err != nil {
+		return errors.Wrap(err, "Error while encoding webhook request")
+	}
+
+	resp, err := incomingWebhookClient.Post(url, "application/json", bytes.NewReader(body))
+
+	if err != nil {
+		return errors.Wrapf(err, "Error while posting on channel %s through its incoming webhook", channelName)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("Incoming webhook of channel %s returned HTTP status %d", channelName, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// postInChannel posts a message about a Trac instance in a configured
+// channel, logging errors
+func (b *Bot) postInChannel(name string, tracId string, message *reply) {
+	if _, err := b.postMessage(name, tracId, message); err != nil {
+		log.Printf("Error while posting on channel %s: %s", name, err)
+	}
+}
@@ -0,0 +1,171 @@
+package bot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/platform/model"
+
+	"github.com/abustany/mattermost-trac-bot/config"
+)
+
+func TestReplyTrac(t *testing.T) {
+	channelConfig := config.ChannelConfig{DefaultTracInstance: "Trac1"}
+
+	for _, test := range []struct {
+		text  string
+		trac  string
+		noDef bool
+	}{
+		{"nothing here", "Trac1", false},
+		{"see #35 and wiki:Page", "Trac1", false},
+		{"see trac2#35 and trac2:r1234", "trac2", false},
+		{"see trac1#35 and #36", "trac1", false},
+		{"see trac2#35 and #36", "", false},
+		{"see #36", "", true},
+	} {
+		c := channelConfig
+
+		if test.noDef {
+			c.DefaultTracInstance = ""
+		}
+
+		if trac := replyTrac(c, test.text); trac != test.trac {
+			t.Errorf("Unexpected Trac for %q: %q, expected %q", test.text, trac, test.trac)
+		}
+	}
+}
+
+func TestWithoutCode(t *testing.T) {
+	for _, test := range []struct {
+		text     string
+		expected string
+	}{
+		{"see #35", "see #35"},
+		{"see `#35` and #36", "see   and #36"},
+		{"see\n```\narr[1234]\n```\nand r12", "see\n \nand r12"},
+		{"an `unterminated #35", "an `unterminated #35"},
+	} {
+		if text := withoutCode(test.text); text != test.expected {
+			t.Errorf("Unexpected text without code for %q: %q, expected %q", test.text, text, test.expected)
+		}
+	}
+}
+
+func TestChangesetRe(t *testing.T) {
+	for _, test := range []struct {
+		text     string
+		revision string
+	}{
+		{"[1234]", "1234"},
+		{"see [1234] and", "1234"},
+		{"see\n[abc1234/repo]", "abc1234"},
+		{"see r1234", "1234"},
+		{"arr[0]", ""},
+		{"list[12]", ""},
+		{"f(x)[1234]", ""},
+		{"m[1][1234]", ""},
+		{"sha[abc1234]", ""},
+	} {
+		revision := ""
+
+		if match := CHANGESET_RE.FindStringSubmatch(test.text); match != nil {
+			revision = match[2] + match[3] + match[5]
+		}
+
+		if revision != test.revision {
+			t.Errorf("Unexpected revision matched in %q: %q, expected %q", test.text, revision, test.revision)
+		}
+	}
+}
+
+func TestReportRe(t *testing.T) {
+	for _, test := range []struct {
+		text     string
+		reportId string
+	}{
+		{"{7}", "7"},
+		{"run {7} again", "7"},
+		{"run trac1:report:7", "7"},
+		{`match \d{3}`, ""},
+		{"x{12}", ""},
+		{"f(){7}", ""},
+		{"a]{7}", ""},
+	} {
+		reportId := ""
+
+		if match := REPORT_RE.FindStringSubmatch(test.text); match != nil {
+			reportId = match[2] + match[3]
+		}
+
+		if reportId != test.reportId {
+			t.Errorf("Unexpected report matched in %q: %q, expected %q", test.text, reportId, test.reportId)
+		}
+	}
+}
+
+func TestPostWithWebhook(t *testing.T) {
+	var request *model.IncomingWebhookRequest
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request, _ = model.IncomingWebhookRequestFromJson(r.Body)
+	}))
+
+	defer server.Close()
+
+	b := &Bot{conf: config.Config{
+		Tracs: map[string]config.TracConfig{"Trac1": {DisplayName: "Trac", IconURL: "http://example.com/trac.png"}},
+		Channels: map[string]config.ChannelConfig{
+			"town-square": {IncomingWebhook: server.URL},
+		},
+	}}
+
+	message := &reply{}
+	message.WriteString("Ticket #35")
+	message.attachments = []*model.SlackAttachment{{Title: "#35: Panic"}}
+
+	if _, err := b.postMessage("town-square", "trac1", message); err != nil {
+		t.Fatalf("Error while posting message: %s", err)
+	}
+
+	if request == nil || request.Text != "Ticket #35" || request.ChannelName != "town-square" || request.Username != "Trac" || request.IconURL != "http://example.com/trac.png" || len(request.Attachments) != 1 {
+		t.Errorf("Unexpected webhook request: %+v", request)
+	}
+
+	// The bot must recognize its own posts
+	if post := (&model.Post{UserId: "u1", Props: request.Props}); !(&Bot{user: &model.User{Id: "bot"}}).ignoredPost(post) {
+		t.Errorf("Posts made through incoming webhooks should be ignored, props: %v", request.Props)
+	}
+
+	if _, err := b.postMessage("town-square", "", message); err != nil {
+		t.Fatalf("Error while posting message: %s", err)
+	}
+
+	if request == nil || request.Username != "" || request.IconURL != "" {
+		t.Errorf("Unexpected webhook request: %+v", request)
+	}
+}
+
+func TestReplyRootId(t *testing.T) {
+	rootPost := &model.Post{Id: "p1"}
+	threadReply := &model.Post{Id: "p2", RootId: "p1", ParentId: "p1"}
+
+	for _, test := range []struct {
+		mode   string
+		post   *model.Post
+		rootId string
+	}{
+		{"", rootPost, ""},
+		{"", threadReply, ""},
+		{config.ReplyModeRoot, threadReply, ""},
+		{config.ReplyModeThread, rootPost, "p1"},
+		{config.ReplyModeThread, threadReply, "p1"},
+		{config.ReplyModeSameAsTrigger, rootPost, ""},
+		{config.ReplyModeSameAsTrigger, threadReply, "p1"},
+	} {
+		if rootId := replyRootId(config.ChannelConfig{ReplyMode: test.mode}, test.post); rootId != test.rootId {
+			t.Errorf("Unexpected root ID in mode %q for post %s: %q", test.mode, test.post.Id, rootId)
+		}
+	}
+}
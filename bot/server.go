@@ -0,0 +1,45 @@
+package bot
+
+import (
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// startHTTPServer serves the HTTP endpoints of the bot, if an address to
+// listen on is configured
+func (b *Bot) startHTTPServer() error {
+	if len(b.conf.Listen) == 0 {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webhook/", b.handleWebhook)
+	mux.HandleFunc("/command", b.handleSlashCommand)
+	mux.HandleFunc("/outgoing", b.handleOutgoingWebhook)
+	mux.HandleFunc("/action", b.handleAction)
+
+	listener, err := net.Listen("tcp", b.conf.Listen)
+
+	if err != nil {
+		return errors.Wrapf(err, "Error while listening on %s", b.conf.Listen)
+	}
+
+	server := &http.Server{Handler: mux}
+
+	b.Lock()
+	b.httpServer = server
+	b.Unlock()
+
+	log.Printf("Serving HTTP endpoints on %s", b.conf.Listen)
+
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			log.Printf("HTTP server error: %s", err)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,241 @@
+package bot
+
+import (
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/mattermost/platform/model"
+	"github.com/pkg/errors"
+
+	"github.com/abustany/mattermost-trac-bot/config"
+	"github.com/abustany/mattermost-trac-bot/trac"
+)
+
+const slashCommandUsage = "Usage:\n" +
+	"- `/trac 123`, `/trac trac2#45`: show a ticket\n" +
+	"- `/trac query [trac ID] status=new&owner=alice`: list the tickets matching a query\n" +
+	"- `/trac search [trac ID] terms`: search tickets, wiki pages and changesets\n" +
+	"- `/trac wiki:Page`, `/trac r1234`...: anything the bot recognizes in messages\n" +
+	"Results are only shown to you, start with `share` to show them to the channel."
+
+// slashCommand is a /trac command sent by Mattermost to the command endpoint
+type slashCommand struct {
+	channelId   string
+	channelName string
+	userId      string
+	text        string
+
+	// Whether the response should be visible to the whole channel
+	share bool
+}
+
+// parseSlashCommand extracts a slash command from the form posted by
+// Mattermost
+func parseSlashCommand(r *http.Request) slashCommand {
+	cmd := slashCommand{
+		channelId:   r.PostForm.Get("channel_id"),
+		channelName: r.PostForm.Get("channel_name"),
+		userId:      r.PostForm.Get("user_id"),
+		text:        strings.TrimSpace(r.PostForm.Get("text")),
+	}
+
+	if fields := strings.Fields(cmd.text); len(fields) > 0 && strings.ToLower(fields[0]) == "share" {
+		cmd.share = true
+		cmd.text = strings.TrimSpace(cmd.text[len(fields[0]):])
+	}
+
+	// Bare ticket numbers are accepted, as in "/trac 123"
+	if len(cmd.text) > 0 && strings.Trim(cmd.text, "0123456789") == "" {
+		cmd.text = "#" + cmd.text
+	}
+
+	return cmd
+}
+
+// handleSlashCommand answers the requests of the /trac slash command. The
+// bot does not need to be a member of the channel, since the response goes
+// back to Mattermost in the HTTP reply.
+func (b *Bot) handleSlashCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	token := r.PostForm.Get("token")
+
+	if len(b.conf.SlashCommandToken) == 0 || subtle.ConstantTimeCompare([]byte(token), []byte(b.conf.SlashCommandToken)) != 1 {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	cmd := parseSlashCommand(r)
+	response := model.CommandResponse{ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL}
+	message := &reply{}
+
+	channelConfig, ok := b.slashChannelConfig(cmd)
+
+	if !ok {
+		formatErrorMessage(message, errors.New("Trac lookups are not enabled in this channel"))
+	} else if len(cmd.text) == 0 || cmd.text == "help" {
+		message.WriteString(slashCommandUsage)
+	} else if err := b.runSlashCommand(message, channelConfig, cmd); err != nil {
+		formatErrorMessage(message, err)
+	} else if cmd.share {
+		response.ResponseType = model.COMMAND_RESPONSE_TYPE_IN_CHANNEL
+	}
+
+	response.Text = message.String()
+	response.Attachments = message.attachments
+
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, response.ToJson())
+}
+
+// slashChannelConfig returns the settings of the channel in which a slash
+// command was typed, those of direct_messages for direct and group messages
+func (b *Bot) slashChannelConfig(cmd slashCommand) (config.ChannelConfig, bool) {
+	if !b.directChannel(cmd.channelId, cmd.channelName) {
+		return b.channelConfig(cmd.channelName)
+	}
+
+	if b.conf.DirectMessages == nil {
+		return config.ChannelConfig{}, false
+	}
+
+	return *b.conf.DirectMessages, true
+}
+
+// runSlashCommand writes the response to a slash command to message
+func (b *Bot) runSlashCommand(message *reply, channelConfig config.ChannelConfig, cmd slashCommand) error {
+	// Only public tickets can be shared in restricted channels, or when
+	// looked up with a personal account
+	if cmd.share {
+		channelConfig = b.sharedChannelConfig(channelConfig, cmd.userId)
+	}
+
+	if cmd.share && restrictsTickets(channelConfig) {
+		channelConfig.RestrictedTickets = config.RestrictedTicketsRedact
+	} else {
+		channelConfig.RestrictedTickets = config.RestrictedTicketsShow
+	}
+
+	fields := strings.Fields(cmd.text)
+
+	switch strings.ToLower(fields[0]) {
+	case "query":
+		return b.handleSlashQuery(message, channelConfig, cmd.userId, fields[1:])
+	case "search":
+		return b.handleSlashSearch(message, channelConfig, cmd.userId, fields[1:])
+	}
+
+	if ref, err := parseTicketRef(cmd.text); err == nil && !cmd.share {
+		ticket, err := b.handleTicketRequest(channelConfig, cmd.userId, ref.tracId, ref.ticketNumber)
+
+		if err != nil {
+			return err
+		}
+
+		return b.formatTicket(message, ref.trac(channelConfig), ticket, b.ticketChanges(channelConfig, cmd.userId, ref), b.conf.TicketChanges)
+	}
+
+	if err := b.handleReferences(message, channelConfig, cmd.userId, cmd.text); err != nil {
+		return err
+	}
+
+	if message.empty() {
+		return errors.New("Nothing to show, try /trac help")
+	}
+
+	return nil
+}
+
+// splitTracId removes the Trac ID from the arguments of a slash command if
+// the first one is the ID of a configured Trac instance
+func (b *Bot) splitTracId(args []string) (string, []string) {
+	if len(args) > 1 {
+		if _, ok := b.tracs[strings.ToLower(args[0])]; ok {
+			return args[0], args[1:]
+		}
+	}
+
+	return "", args
+}
+
+func (b *Bot) handleSlashQuery(message *reply, channelConfig config.ChannelConfig, userId string, args []string) error {
+	tracId, args := b.splitTracId(args)
+
+	if len(args) == 0 {
+		return errors.New("Usage: /trac query [trac ID] status=new&owner=alice")
+	}
+
+	query := strings.Join(args, " ")
+	result, hidden, err := b.handleQueryRequest(channelConfig, userId, tracId, query)
+
+	if err != nil {
+		return err
+	}
+
+	if err := formatQueryMessage(message, b.queryTemplate, query, result); err != nil {
+		return err
+	}
+
+	formatHiddenTickets(message, hidden)
+
+	return nil
+}
+
+func (b *Bot) handleSlashSearch(message *reply, channelConfig config.ChannelConfig, userId string, args []string) error {
+	tracId, args := b.splitTracId(args)
+
+	if len(args) == 0 {
+		return errors.New("Usage: /trac search [trac ID] terms")
+	}
+
+	query := strings.Join(args, " ")
+	tracId, client, err := b.resolveTrac(channelConfig, userId, tracId, "search "+query)
+
+	if err != nil {
+		return err
+	}
+
+	results, err := client.Search(query, nil)
+
+	if err != nil {
+		return errors.Wrapf(err, "Error while searching %s on %s", query, tracId)
+	}
+
+	results, hidden := b.publicSearchResults(channelConfig, tracId, results)
+
+	if err := formatSearchMessage(message, b.searchTemplate, query, results, b.conf.QueryMaxResults); err != nil {
+		return err
+	}
+
+	formatHiddenTickets(message, hidden)
+
+	return nil
+}
+
+// searchMessage is the data passed to the search template
+type searchMessage struct {
+	Query   string
+	Results []trac.SearchResult
+	Total   int
+}
+
+func formatSearchMessage(w io.Writer, tmpl *template.Template, query string, results []trac.SearchResult, maxResults int) error {
+	data := searchMessage{query, results, len(results)}
+
+	if len(data.Results) > maxResults {
+		data.Results = data.Results[0:maxResults]
+	}
+
+	return errors.Wrap(tmpl.Execute(w, data), "Error while rendering search template")
+}
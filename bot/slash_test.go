@@ -0,0 +1,112 @@
+package bot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/platform/model"
+
+	"github.com/abustany/mattermost-trac-bot/config"
+)
+
+func TestParseSlashCommand(t *testing.T) {
+	for _, test := range []struct {
+		text  string
+		share bool
+		cmd   string
+	}{
+		{"", false, ""},
+		{"123", false, "#123"},
+		{"  trac2#45 ", false, "trac2#45"},
+		{"share 123", true, "#123"},
+		{"Share query status=new", true, "query status=new"},
+		{"shared", false, "shared"},
+	} {
+		req := httptest.NewRequest("POST", "/command", strings.NewReader(url.Values{"text": {test.text}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.ParseForm()
+
+		cmd := parseSlashCommand(req)
+
+		if cmd.share != test.share || cmd.text != test.cmd {
+			t.Errorf("Unexpected command for %q: %+v", test.text, cmd)
+		}
+	}
+}
+
+func TestSlashCommand(t *testing.T) {
+	b := &Bot{conf: config.Config{
+		SlashCommandToken: "t0ken",
+		Channels:          map[string]config.ChannelConfig{"town-square": {}},
+	}}
+
+	for _, test := range []struct {
+		token   string
+		channel string
+		status  int
+		text    string
+	}{
+		{"wrong", "town-square", http.StatusUnauthorized, ""},
+		{"t0ken", "off-topic", http.StatusOK, "not enabled"},
+		{"t0ken", "town-square", http.StatusOK, "Usage"},
+	} {
+		form := url.Values{"token": {test.token}, "channel_name": {test.channel}, "text": {"help"}}
+		req := httptest.NewRequest("POST", "/command", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		b.handleSlashCommand(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("Unexpected status for token %s in %s: %d", test.token, test.channel, w.Code)
+			continue
+		}
+
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		response := model.CommandResponseFromJson(w.Body)
+
+		if response.ResponseType != model.COMMAND_RESPONSE_TYPE_EPHEMERAL || !strings.Contains(response.Text, test.text) {
+			t.Errorf("Unexpected response in %s: %+v", test.channel, response)
+		}
+	}
+}
+
+func TestSlashChannelConfig(t *testing.T) {
+	b := &Bot{
+		conf: config.Config{
+			Channels:       map[string]config.ChannelConfig{"town-square": {DefaultTracInstance: "trac1"}},
+			DirectMessages: &config.ChannelConfig{DefaultTracInstance: "trac2"},
+		},
+		directChannels: map[string]string{"dm1": model.CHANNEL_DIRECT},
+	}
+
+	for _, test := range []struct {
+		channelId   string
+		channelName string
+		trac        string
+	}{
+		{"c1", "town-square", "trac1"},
+		{"dm1", "mvjz6hgoc3bxdrejzsfpgfc3yr__qa7yqnzcbfgfjm6hqmcyjrmt5o", "trac2"},
+		{"dm2", "mvjz6hgoc3bxdrejzsfpgfc3yr__qa7yqnzcbfgfjm6hqmcyjrmt5o", "trac2"},
+		{"gm1", "5f7a3d6a2b1c4e9f8d0a1b2c3d4e5f6a7b8c9d0e", "trac2"},
+		{"c2", "off-topic", ""},
+	} {
+		channelConfig, _ := b.slashChannelConfig(slashCommand{channelId: test.channelId, channelName: test.channelName})
+
+		if channelConfig.DefaultTracInstance != test.trac {
+			t.Errorf("Unexpected settings for channel %s: %+v", test.channelName, channelConfig)
+		}
+	}
+
+	b.conf.DirectMessages = nil
+
+	if _, ok := b.slashChannelConfig(slashCommand{channelId: "dm1"}); ok {
+		t.Errorf("Slash commands should be refused in direct messages when direct_messages is not set")
+	}
+}
@@ -0,0 +1,355 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/abustany/mattermost-trac-bot/config"
+	"github.com/abustany/mattermost-trac-bot/trac"
+)
+
+// timelineState holds the date of the last timeline event posted for each
+// Trac instance
+type timelineState struct {
+	path string
+
+	// Maps normalized Trac IDs to the date of their last posted event
+	Marks map[string]time.Time `json:"marks"`
+
+	// Maps normalized Trac IDs to the URLs of the events posted at the date of
+	// their mark, which the next poll returns again
+	Seen map[string][]string `json:"seen"`
+}
+
+// loadTimelineState loads the state saved at path. An empty state is returned
+// if path is empty or does not exist yet.
+func loadTimelineState(path string) (*timelineState, error) {
+	state := &timelineState{path: path, Marks: map[string]time.Time{}, Seen: map[string][]string{}}
+
+	if len(path) == 0 {
+		return state, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error while reading %s", path)
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, errors.Wrapf(err, "Error while decoding %s", path)
+	}
+
+	// Saved by a version not recording them
+	if state.Seen == nil {
+		state.Seen = map[string][]string{}
+	}
+
+	return state, nil
+}
+
+// posted tells whether an event of a Trac instance was already posted
+func (s *timelineState) posted(id string, event trac.TimelineEvent) bool {
+	mark := s.Marks[id]
+
+	return event.Date.Before(mark) || (event.Date.Equal(mark) && stringSliceContainsNC(s.Seen[id], event.URL))
+}
+
+// record moves the mark of a Trac instance to a posted event
+func (s *timelineState) record(id string, event trac.TimelineEvent) {
+	if event.Date.After(s.Marks[id]) {
+		s.Marks[id] = event.Date
+		s.Seen[id] = nil
+	}
+
+	s.Seen[id] = append(s.Seen[id], event.URL)
+}
+
+func (s *timelineState) save() error {
+	if len(s.path) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(s)
+
+	if err != nil {
+		return errors.Wrap(err, "Error while encoding timeline state")
+	}
+
+	if err := ioutil.WriteFile(s.path+".tmp", data, 0644); err != nil {
+		return errors.Wrapf(err, "Error while writing %s", s.path)
+	}
+
+	return errors.Wrapf(os.Rename(s.path+".tmp", s.path), "Error while writing %s", s.path)
+}
+
+// subscribedEvents returns the kinds of events to retrieve from the timeline
+// of each subscribed Trac instance, keyed by normalized Trac ID. A nil slice
+// means all events.
+func subscribedEvents(channels map[string]config.ChannelConfig) map[string][]string {
+	events := map[string][]string{}
+	all := map[string]bool{}
+
+	for _, channelConfig := range channels {
+		for _, subscription := range channelConfig.Subscriptions {
+			id := strings.ToLower(subscription.Trac)
+
+			if len(subscription.Events) == 0 {
+				all[id] = true
+			}
+
+			for _, event := range subscription.Events {
+				if !stringSliceContainsNC(events[id], event) {
+					events[id] = append(events[id], event)
+				}
+			}
+		}
+	}
+
+	for id := range all {
+		events[id] = nil
+	}
+
+	return events
+}
+
+// withoutTicketEvents removes the ticket events from a list of event kinds, nil
+// meaning all kinds
+func withoutTicketEvents(kinds []string) []string {
+	if kinds == nil {
+		kinds = config.TimelineEvents
+	}
+
+	var filtered []string
+
+	for _, kind := range kinds {
+		if !strings.HasSuffix(kind, "ticket") {
+			filtered = append(filtered, kind)
+		}
+	}
+
+	return filtered
+}
+
+// matchesSubscription returns whether a timeline event should be posted for a
+// subscription. ticket holds the fields of the ticket of ticket events, it is
+// nil if they could not be retrieved.
+func matchesSubscription(subscription config.SubscriptionConfig, event trac.TimelineEvent, ticket trac.Ticket) bool {
+	if len(subscription.Events) > 0 && !stringSliceContainsNC(subscription.Events, event.Kind) {
+		return false
+	}
+
+	if len(event.TicketID) == 0 || len(subscription.Filters) == 0 {
+		return true
+	}
+
+	if ticket == nil {
+		return false
+	}
+
+	for field, value := range subscription.Filters {
+		if ticket[field] != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// startTimeline starts polling the timelines of the Trac instances to which
+// channels subscribed
+func (b *Bot) startTimeline() error {
+	events := subscribedEvents(b.conf.Channels)
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	tracIds := makeTracIds(b.conf.Tracs)
+
+	for id, kinds := range events {
+		// Ticket events of these instances are pushed through the webhook
+		if len(b.conf.Tracs[tracIds[id]].WebhookSecret) == 0 {
+			continue
+		}
+
+		if kinds = withoutTicketEvents(kinds); len(kinds) == 0 {
+			delete(events, id)
+		} else {
+			events[id] = kinds
+		}
+	}
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	state, err := loadTimelineState(b.conf.StateFile)
+
+	if err != nil {
+		return errors.Wrap(err, "Error while loading timeline state")
+	}
+
+	now := time.Now()
+
+	for id := range events {
+		if _, ok := state.Marks[id]; !ok {
+			state.Marks[id] = now
+		}
+	}
+
+	go b.pollTimeline(state, events)
+
+	return nil
+}
+
+func (b *Bot) pollTimeline(state *timelineState, events map[string][]string) {
+	ticker := time.NewTicker(time.Duration(b.conf.TimelinePollInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.quit:
+			return
+		case <-ticker.C:
+		}
+
+		for id, kinds := range events {
+			b.pollTracTimeline(state, id, kinds)
+		}
+
+		if err := state.save(); err != nil {
+			log.Printf("Error while saving timeline state: %s", err)
+		}
+	}
+}
+
+// pollTracTimeline posts the events which occurred on a Trac instance since
+// the last poll
+func (b *Bot) pollTracTimeline(state *timelineState, id string, kinds []string) {
+	client := b.tracs[id]
+	events, err := client.GetTimeline(state.Marks[id], time.Now(), kinds)
+
+	if err != nil {
+		log.Printf("Error while polling timeline of %s: %s", id, err)
+		return
+	}
+
+	// The events of the mark second are returned again
+	var newEvents []trac.TimelineEvent
+
+	for _, event := range events {
+		if !state.posted(id, event) {
+			newEvents = append(newEvents, event)
+		}
+	}
+
+	tickets := timelineTickets(client, newEvents)
+	public := timelineTickets(b.publicTracs[id], newEvents)
+
+	for _, event := range newEvents {
+		b.postTimelineEvent(id, event, tickets[event.TicketID], public[event.TicketID] != nil)
+		state.record(id, event)
+	}
+}
+
+// timelineTickets retrieves the tickets of the ticket events, keyed by ID.
+// Tickets which cannot be retrieved are missing from the result.
+func timelineTickets(client *trac.Client, events []trac.TimelineEvent) map[string]trac.Ticket {
+	var ids []string
+	tickets := map[string]trac.Ticket{}
+
+	for _, event := range events {
+		if len(event.TicketID) > 0 && !stringSliceContainsNC(ids, event.TicketID) {
+			ids = append(ids, event.TicketID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return tickets
+	}
+
+	batchTickets, errs := client.GetTickets(ids)
+
+	for i, id := range ids {
+		if errs[i] == nil {
+			tickets[id] = batchTickets[i]
+		}
+	}
+
+	return tickets
+}
+
+// timelineMessage is the data passed to the timeline templates
+type timelineMessage struct {
+	trac.TimelineEvent
+	Trac   string
+	Ticket trac.Ticket
+}
+
+// subscribedChannels returns the names of the channels subscribed to an event
+// of a Trac instance, among those the bot is a member of. Only the channels
+// listed by name in the configuration can subscribe, not those configured by
+// channel rules.
+func (b *Bot) subscribedChannels(tracId string, event trac.TimelineEvent, ticket trac.Ticket) []string {
+	var names []string
+
+	for name, channelConfig := range b.conf.Channels {
+		// The channels are updated by the WebSocket goroutine
+		if len(b.channelId(name)) == 0 {
+			continue
+		}
+
+		for _, subscription := range channelConfig.Subscriptions {
+			if strings.ToLower(subscription.Trac) == tracId && matchesSubscription(subscription, event, ticket) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+
+	return names
+}
+
+// restrictsTickets returns whether a channel hides the details of non-public
+// tickets
+func restrictsTickets(channelConfig config.ChannelConfig) bool {
+	return channelConfig.RestrictedTickets == config.RestrictedTicketsRedact || channelConfig.RestrictedTickets == config.RestrictedTicketsDirectMessage
+}
+
+// postTimelineEvent posts an event in the channels subscribed to it. public
+// tells whether the ticket of a ticket event is visible to the public identity
+// of the Trac instance.
+func (b *Bot) postTimelineEvent(tracId string, event trac.TimelineEvent, ticket trac.Ticket, public bool) {
+	tmpl := b.timelineTemplates[event.Kind]
+
+	if tmpl == nil {
+		tmpl = b.timelineTemplates["default"]
+	}
+
+	for _, name := range b.subscribedChannels(tracId, event, ticket) {
+		message := &reply{}
+
+		channelConfig, _ := b.channelConfig(name)
+
+		if len(event.TicketID) > 0 && restrictsTickets(channelConfig) && !public {
+			fmt.Fprintf(message, ":lock: [Ticket #%s](%s) was updated", event.TicketID, event.URL)
+		} else if err := tmpl.Execute(message, timelineMessage{event, tracId, ticket}); err != nil {
+			log.Printf("Error while rendering timeline template: %s", err)
+			continue
+		}
+
+		b.postInChannel(name, tracId, message)
+	}
+}
@@ -0,0 +1,121 @@
+package bot
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/mattermost/platform/model"
+
+	"github.com/abustany/mattermost-trac-bot/config"
+	"github.com/abustany/mattermost-trac-bot/trac"
+)
+
+func TestSubscribedEvents(t *testing.T) {
+	events := subscribedEvents(map[string]config.ChannelConfig{
+		"dev": {Subscriptions: []config.SubscriptionConfig{
+			{Trac: "Trac1", Events: []string{"newticket", "closedticket"}},
+			{Trac: "trac2"},
+		}},
+		"qa": {Subscriptions: []config.SubscriptionConfig{
+			{Trac: "trac1", Events: []string{"closedticket", "wiki"}},
+			{Trac: "trac2", Events: []string{"wiki"}},
+		}},
+	})
+
+	sort.Strings(events["trac1"])
+
+	expected := map[string][]string{
+		"trac1": {"closedticket", "newticket", "wiki"},
+		"trac2": nil,
+	}
+
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Unexpected subscribed events: %v", events)
+	}
+}
+
+func TestMatchesSubscription(t *testing.T) {
+	subscription := config.SubscriptionConfig{
+		Trac:    "trac1",
+		Events:  []string{"newticket", "changeset"},
+		Filters: map[string]string{"component": "core"},
+	}
+
+	ticketEvent := trac.TimelineEvent{Kind: "newticket", TicketID: "35"}
+
+	if !matchesSubscription(subscription, ticketEvent, trac.Ticket{"component": "core"}) {
+		t.Errorf("Ticket event matching the filters should match")
+	}
+
+	if matchesSubscription(subscription, ticketEvent, trac.Ticket{"component": "ui"}) {
+		t.Errorf("Ticket event not matching the filters should not match")
+	}
+
+	if matchesSubscription(subscription, ticketEvent, nil) {
+		t.Errorf("Ticket event without ticket data should not match filters")
+	}
+
+	if !matchesSubscription(subscription, trac.TimelineEvent{Kind: "changeset"}, nil) {
+		t.Errorf("Filters should not apply to changesets")
+	}
+
+	if matchesSubscription(subscription, trac.TimelineEvent{Kind: "wiki"}, nil) {
+		t.Errorf("Events not subscribed to should not match")
+	}
+}
+
+func TestSubscribedChannels(t *testing.T) {
+	subscriptions := []config.SubscriptionConfig{{Trac: "trac1"}}
+
+	b := &Bot{
+		conf: config.Config{Channels: map[string]config.ChannelConfig{
+			"dev":    {Subscriptions: subscriptions},
+			"qa":     {Subscriptions: subscriptions},
+			"random": {},
+		}},
+		channels: map[string]*model.Channel{
+			"dev":    {Id: "c1", Name: "dev"},
+			"random": {Id: "c3", Name: "random"},
+		},
+	}
+
+	// The bot is not a member of qa
+	names := b.subscribedChannels("trac1", trac.TimelineEvent{Kind: "wiki"}, nil)
+
+	if !reflect.DeepEqual(names, []string{"dev"}) {
+		t.Errorf("Unexpected subscribed channels: %v", names)
+	}
+}
+
+func TestTimelineStatePosted(t *testing.T) {
+	mark := time.Date(2017, 1, 4, 9, 0, 0, 0, time.UTC)
+	state := &timelineState{Marks: map[string]time.Time{"trac1": mark}, Seen: map[string][]string{}}
+
+	first := trac.TimelineEvent{Date: mark, URL: "http://trac/changeset/1200"}
+	second := trac.TimelineEvent{Date: mark, URL: "http://trac/ticket/35#comment:3"}
+	later := trac.TimelineEvent{Date: mark.Add(time.Second), URL: "http://trac/ticket/36"}
+
+	state.record("trac1", first)
+
+	if !state.posted("trac1", first) {
+		t.Errorf("A recorded event should be posted")
+	}
+
+	// Same second as the mark
+	if state.posted("trac1", second) {
+		t.Errorf("An event of the mark second should not be posted until recorded")
+	}
+
+	state.record("trac1", second)
+	state.record("trac1", later)
+
+	if !state.Marks["trac1"].Equal(later.Date) || len(state.Seen["trac1"]) != 1 {
+		t.Errorf("Unexpected state after a later event: %v %v", state.Marks, state.Seen)
+	}
+
+	if !state.posted("trac1", second) || state.posted("trac1", trac.TimelineEvent{Date: later.Date, URL: "http://trac/wiki/Page"}) {
+		t.Errorf("Unexpected posted status after a later event")
+	}
+}
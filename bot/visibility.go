@@ -0,0 +1,230 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/mattermost/platform/model"
+	"github.com/pkg/errors"
+
+	"github.com/abustany/mattermost-trac-bot/config"
+	"github.com/abustany/mattermost-trac-bot/trac"
+)
+
+// errNoPublicIdentity hides the tickets of the Trac instances without a
+// public identity
+var errNoPublicIdentity = errors.New("No public identity")
+
+// publicTickets checks, for each ticket reference, whether the public identity
+// of its Trac instance can see the ticket. The returned error is nil for the
+// public tickets, and trac.ErrPermissionDenied for those the public identity
+// is not allowed to see. All tickets are public if the channel does not
+// restrict ticket details. Tickets which could not be retrieved
+// (errs[i] != nil) keep their error.
+func (b *Bot) publicTickets(channelConfig config.ChannelConfig, refs []ticketRef, errs []error) []error {
+	public := make([]error, len(refs))
+
+	if !restrictsTickets(channelConfig) {
+		return public
+	}
+
+	// Maps public clients to the indices of the refs they should check
+	batches := map[*trac.Client][]int{}
+
+	for i, ref := range refs {
+		if errs[i] != nil {
+			public[i] = errs[i]
+			continue
+		}
+
+		if client := b.publicTracs[strings.ToLower(ref.trac(channelConfig))]; client != nil {
+			batches[client] = append(batches[client], i)
+		} else {
+			public[i] = errNoPublicIdentity
+		}
+	}
+
+	for client, indices := range batches {
+		ids := make([]string, len(indices))
+
+		for j, i := range indices {
+			ids[j] = refs[i].ticketNumber
+		}
+
+		// Any error hides the ticket, not only a permission error
+		_, batchErrs := client.GetTickets(ids)
+
+		for j, i := range indices {
+			public[i] = batchErrs[j]
+		}
+	}
+
+	return public
+}
+
+// TICKET_URL_RE extracts the ticket number from the URL of a ticket
+var TICKET_URL_RE = regexp.MustCompile(`/ticket/(\d+)(?:[?#].*)?$`)
+
+// errNoTicketNumber hides the query and report rows without a ticket number
+var errNoTicketNumber = errors.New("No ticket number")
+
+// publicTicketNumbers tells, for each ticket number of a Trac instance,
+// whether the ticket can be shown in the channel. Empty numbers can only be
+// shown if the channel does not restrict ticket details.
+func (b *Bot) publicTicketNumbers(channelConfig config.ChannelConfig, tracId string, numbers []string) []bool {
+	refs := make([]ticketRef, len(numbers))
+	errs := make([]error, len(numbers))
+
+	for i, number := range numbers {
+		refs[i] = ticketRef{tracId: tracId, ticketNumber: number}
+
+		if len(number) == 0 {
+			errs[i] = errNoTicketNumber
+		}
+	}
+
+	public := make([]bool, len(numbers))
+
+	for i, err := range b.publicTickets(channelConfig, refs, errs) {
+		public[i] = err == nil
+	}
+
+	return public
+}
+
+// publicRows keeps the query or report rows showing a ticket that can be shown
+// in the channel, and returns the number of hidden rows
+func (b *Bot) publicRows(channelConfig config.ChannelConfig, tracId string, rows []trac.Ticket) ([]trac.Ticket, int) {
+	numbers := make([]string, len(rows))
+
+	for i, row := range rows {
+		if numbers[i] = row["ticket"]; len(numbers[i]) == 0 {
+			numbers[i] = row["id"]
+		}
+	}
+
+	var kept []trac.Ticket
+
+	for i, public := range b.publicTicketNumbers(channelConfig, tracId, numbers) {
+		if public {
+			kept = append(kept, rows[i])
+		}
+	}
+
+	return kept, len(rows) - len(kept)
+}
+
+// publicSearchResults drops the ticket search results that cannot be shown in
+// the channel, and returns the number of dropped results. Other results are
+// kept.
+func (b *Bot) publicSearchResults(channelConfig config.ChannelConfig, tracId string, results []trac.SearchResult) ([]trac.SearchResult, int) {
+	var numbers []string
+	var indices []int
+
+	for i, result := range results {
+		if match := TICKET_URL_RE.FindStringSubmatch(result.URL); match != nil {
+			numbers = append(numbers, match[1])
+			indices = append(indices, i)
+		}
+	}
+
+	hidden := map[int]bool{}
+
+	for j, public := range b.publicTicketNumbers(channelConfig, tracId, numbers) {
+		hidden[indices[j]] = !public
+	}
+
+	var kept []trac.SearchResult
+
+	for i, result := range results {
+		if !hidden[i] {
+			kept = append(kept, result)
+		}
+	}
+
+	return kept, len(results) - len(kept)
+}
+
+// formatHiddenTickets mentions the tickets left out of a query, report or
+// search
+func formatHiddenTickets(w io.Writer, hidden int) {
+	if hidden > 0 {
+		fmt.Fprintf(w, "\n:lock: %d non-public tickets are not shown", hidden)
+	}
+}
+
+// formatRestrictedTicket writes the redacted version of a non-public ticket to
+// message and, if the channel asks for it, its details to private. publicErr
+// tells why the ticket is not public.
+func (b *Bot) formatRestrictedTicket(message *reply, private *bytes.Buffer, channelConfig config.ChannelConfig, userId string, ref ticketRef, t trac.Ticket, publicErr error) error {
+	fmt.Fprintf(message, ":lock: [Ticket #%s](%s)", ref.ticketNumber, t["_url"])
+
+	// The ticket may well be public, but it cannot be told
+	if errors.Cause(publicErr) != trac.ErrPermissionDenied {
+		log.Printf("Error while checking the visibility of ticket #%s: %s", ref.ticketNumber, publicErr)
+		message.WriteString(" (visibility could not be checked)")
+	}
+
+	if channelConfig.RestrictedTickets != config.RestrictedTicketsDirectMessage || len(userId) == 0 {
+		return nil
+	}
+
+	message.WriteString(", details sent privately")
+
+	if err := formatTicketMessage(private, b.ticketTemplate, t, b.ticketChanges(channelConfig, userId, ref), b.conf.TicketChanges); err != nil {
+		return err
+	}
+
+	private.WriteString("\n")
+
+	return nil
+}
+
+// formatVisibleTicket writes a ticket to message like formatTicket, or its
+// redacted version if the channel restricts ticket details and the public
+// identity of its Trac instance cannot see it
+func (b *Bot) formatVisibleTicket(message *reply, channelConfig config.ChannelConfig, userId string, ref ticketRef, t trac.Ticket, changes []trac.TicketChange, maxChanges int) error {
+	publicErr := b.publicTickets(channelConfig, []ticketRef{ref}, []error{nil})[0]
+
+	if publicErr == nil {
+		return b.formatTicket(message, ref.trac(channelConfig), t, changes, maxChanges)
+	}
+
+	private := bytes.NewBuffer(nil)
+
+	if err := b.formatRestrictedTicket(message, private, channelConfig, userId, ref, t, publicErr); err != nil {
+		return err
+	}
+
+	if private.Len() > 0 {
+		if err := b.sendDirectMessage(userId, private.String()); err != nil {
+			return errors.Wrap(err, "Error while sending restricted ticket")
+		}
+	}
+
+	return nil
+}
+
+// sendDirectMessage posts text in the direct message channel between the bot
+// and a user
+func (b *Bot) sendDirectMessage(userId string, text string) error {
+	res, err := b.client.CreateDirectChannel(userId)
+
+	if err != nil {
+		return errors.Wrapf(err, "Error while opening direct message channel with user %s", userId)
+	}
+
+	post := model.Post{}
+	post.ChannelId = res.Data.(*model.Channel).Id
+	post.Message = text
+
+	if _, err := b.client.CreatePost(&post); err != nil {
+		return errors.Wrapf(err, "Error while sending direct message to user %s", userId)
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/pkg/errors"
+
+	"github.com/abustany/mattermost-trac-bot/config"
+	"github.com/abustany/mattermost-trac-bot/trac"
+)
+
+// testPublicTrac starts a Trac instance on which anonymous users can only see
+// the given tickets, and returns a client using the public identity
+func testPublicTrac(t *testing.T, public ...string) (*trac.Client, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/ticket/")
+
+		if !stringSliceContainsNC(public, id) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		fmt.Fprintf(w, "id,summary\n%s,Public ticket\n", id)
+	}))
+
+	client, err := trac.New(server.URL, trac.AuthBasic, false)
+
+	if err != nil {
+		server.Close()
+		t.Fatalf("Error while creating Trac client: %s", err)
+	}
+
+	return client, server.Close
+}
+
+func TestPublicTickets(t *testing.T) {
+	client, closeServer := testPublicTrac(t, "1")
+	defer closeServer()
+
+	b := &Bot{publicTracs: map[string]*trac.Client{"trac1": client}}
+	refs := []ticketRef{{"trac1", "1"}, {"trac1", "2"}, {"trac1", "3"}, {"trac2", "4"}}
+	errs := []error{nil, nil, fmt.Errorf("Not found"), nil}
+
+	public := b.publicTickets(config.ChannelConfig{}, refs, errs)
+
+	for i, err := range public {
+		if err != nil {
+			t.Errorf("Ticket %v should be public when tickets are shown, got %s", refs[i], err)
+		}
+	}
+
+	public = b.publicTickets(config.ChannelConfig{RestrictedTickets: config.RestrictedTicketsRedact}, refs, errs)
+
+	if public[0] != nil {
+		t.Errorf("Ticket #1 should be public, got %s", public[0])
+	}
+
+	if errors.Cause(public[1]) != trac.ErrPermissionDenied {
+		t.Errorf("Ticket #2 should be refused, got %v", public[1])
+	}
+
+	if public[2] != errs[2] {
+		t.Errorf("Ticket #3 should keep its error, got %v", public[2])
+	}
+
+	if public[3] != errNoPublicIdentity {
+		t.Errorf("Ticket #4 has no public identity, got %v", public[3])
+	}
+}
+
+func TestFormatRestrictedTicket(t *testing.T) {
+	b := &Bot{ticketTemplate: template.Must(template.New("ticket").Parse("#{{.id}}: {{.summary}}"))}
+
+	ticket := trac.Ticket{"id": "2", "summary": "Secret", "_url": "http://trac/ticket/2"}
+	ref := ticketRef{"trac1", "2"}
+
+	testData := []struct {
+		restrictedTickets string
+		publicErr         error
+		expected          string
+		private           string
+	}{
+		{config.RestrictedTicketsRedact, trac.ErrPermissionDenied, ":lock: [Ticket #2](http://trac/ticket/2)", ""},
+		{config.RestrictedTicketsRedact, fmt.Errorf("Timeout"), ":lock: [Ticket #2](http://trac/ticket/2) (visibility could not be checked)", ""},
+		{config.RestrictedTicketsDirectMessage, trac.ErrPermissionDenied, ":lock: [Ticket #2](http://trac/ticket/2), details sent privately", "#2: Secret\n"},
+	}
+
+	for _, test := range testData {
+		message := &reply{}
+		private := bytes.NewBuffer(nil)
+		channelConfig := config.ChannelConfig{RestrictedTickets: test.restrictedTickets}
+
+		if err := b.formatRestrictedTicket(message, private, channelConfig, "u1", ref, ticket, test.publicErr); err != nil {
+			t.Errorf("Error while formatting restricted ticket: %s", err)
+			continue
+		}
+
+		if message.String() != test.expected || private.String() != test.private {
+			t.Errorf("Unexpected restricted ticket %q (private %q), expected %q (private %q)", message.String(), private.String(), test.expected, test.private)
+		}
+
+		if strings.Contains(message.String(), "Secret") {
+			t.Errorf("Restricted ticket details leaked: %s", message.String())
+		}
+	}
+}
+
+func TestFormatVisibleTicket(t *testing.T) {
+	client, closeServer := testPublicTrac(t, "1")
+	defer closeServer()
+
+	b := &Bot{
+		publicTracs:    map[string]*trac.Client{"trac1": client},
+		ticketTemplate: template.Must(template.New("ticket").Parse("#{{.id}}: {{.summary}}")),
+	}
+
+	for _, test := range []struct {
+		restrictedTickets string
+		ticketNumber      string
+		expected          string
+	}{
+		{config.RestrictedTicketsShow, "2", "#2: Secret"},
+		{config.RestrictedTicketsRedact, "1", "#1: Secret"},
+		{config.RestrictedTicketsRedact, "2", ":lock: [Ticket #2](http://trac/ticket/2)"},
+	} {
+		message := &reply{}
+		channelConfig := config.ChannelConfig{RestrictedTickets: test.restrictedTickets}
+		ticket := trac.Ticket{"id": test.ticketNumber, "summary": "Secret", "_url": "http://trac/ticket/" + test.ticketNumber}
+
+		if err := b.formatVisibleTicket(message, channelConfig, "u1", ticketRef{"trac1", test.ticketNumber}, ticket, nil, 0); err != nil {
+			t.Errorf("Error while formatting ticket #%s: %s", test.ticketNumber, err)
+			continue
+		}
+
+		if message.String() != test.expected {
+			t.Errorf("Unexpected ticket #%s with restricted_tickets %s: %q, expected %q", test.ticketNumber, test.restrictedTickets, message.String(), test.expected)
+		}
+	}
+}
+
+func TestPublicRows(t *testing.T) {
+	client, closeServer := testPublicTrac(t, "1")
+	defer closeServer()
+
+	b := &Bot{publicTracs: map[string]*trac.Client{"trac1": client}}
+	rows := []trac.Ticket{{"id": "1", "summary": "Public"}, {"id": "2", "summary": "Secret"}, {"ticket": "1"}, {"summary": "No ticket"}}
+
+	if kept, hidden := b.publicRows(config.ChannelConfig{}, "trac1", rows); len(kept) != 4 || hidden != 0 {
+		t.Errorf("All rows should be shown when tickets are shown, got %v (%d hidden)", kept, hidden)
+	}
+
+	kept, hidden := b.publicRows(config.ChannelConfig{RestrictedTickets: config.RestrictedTicketsRedact}, "trac1", rows)
+
+	if len(kept) != 2 || kept[0]["summary"] != "Public" || kept[1]["ticket"] != "1" || hidden != 2 {
+		t.Errorf("Unexpected public rows: %v (%d hidden)", kept, hidden)
+	}
+}
+
+func TestPublicSearchResults(t *testing.T) {
+	client, closeServer := testPublicTrac(t, "1")
+	defer closeServer()
+
+	b := &Bot{publicTracs: map[string]*trac.Client{"trac1": client}}
+	results := []trac.SearchResult{
+		{URL: "http://trac/ticket/1", Title: "#1: Public"},
+		{URL: "http://trac/ticket/2", Title: "#2: Secret"},
+		{URL: "http://trac/wiki/Start", Title: "Start"},
+	}
+
+	kept, hidden := b.publicSearchResults(config.ChannelConfig{RestrictedTickets: config.RestrictedTicketsDirectMessage}, "trac1", results)
+
+	if len(kept) != 2 || kept[0].Title != "#1: Public" || kept[1].Title != "Start" || hidden != 1 {
+		t.Errorf("Unexpected public search results: %v (%d hidden)", kept, hidden)
+	}
+
+	message := bytes.NewBuffer(nil)
+	formatHiddenTickets(message, hidden)
+
+	if !strings.Contains(message.String(), "1 non-public tickets") {
+		t.Errorf("Unexpected hidden tickets mention: %s", message.String())
+	}
+}
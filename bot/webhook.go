@@ -0,0 +1,196 @@
+package bot
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/abustany/mattermost-trac-bot/trac"
+)
+
+// ticketNotification is the JSON payload accepted by the webhook endpoint,
+// describing a change made to a ticket:
+//
+//	{
+//	  "ticket": 35,
+//	  "event": "changed",
+//	  "author": "alice",
+//	  "comment": "Fixed in r1200",
+//	  "changes": {"status": {"old": "new", "new": "closed"}}
+//	}
+type ticketNotification struct {
+	Ticket interface{} `json:"ticket"`
+
+	// "created" or "changed" (default)
+	Event string `json:"event"`
+
+	Author  string `json:"author"`
+	Comment string `json:"comment"`
+
+	Changes map[string]struct {
+		Old string `json:"old"`
+		New string `json:"new"`
+	} `json:"changes"`
+}
+
+// ticketID returns the ID of the notified ticket, which can be given either
+// as a number or as a string
+func (n ticketNotification) ticketID() (string, error) {
+	id := strings.TrimPrefix(fmt.Sprint(n.Ticket), "#")
+
+	if n.Ticket == nil || len(id) == 0 || strings.Trim(id, "0123456789") != "" {
+		return "", errors.Errorf("Invalid ticket ID: %v", n.Ticket)
+	}
+
+	return id, nil
+}
+
+// kind returns the timeline event kind matching the notification
+func (n ticketNotification) kind() string {
+	if n.Event == "created" {
+		return trac.TimelineNewTicket
+	}
+
+	if status, ok := n.Changes["status"]; ok {
+		if status.New == "closed" {
+			return trac.TimelineClosedTicket
+		}
+
+		if status.Old == "closed" {
+			return trac.TimelineReopenedTicket
+		}
+	}
+
+	return trac.TimelineEditedTicket
+}
+
+// ticketChanges converts the notification to changelog entries, the comment
+// coming last
+func (n ticketNotification) ticketChanges(date time.Time) []trac.TicketChange {
+	var changes []trac.TicketChange
+	var fields []string
+
+	for field := range n.Changes {
+		fields = append(fields, field)
+	}
+
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		changes = append(changes, trac.TicketChange{
+			Time:      date,
+			Author:    n.Author,
+			Field:     field,
+			OldValue:  n.Changes[field].Old,
+			NewValue:  n.Changes[field].New,
+			Permanent: true,
+		})
+	}
+
+	if len(n.Comment) > 0 {
+		changes = append(changes, trac.TicketChange{
+			Time:      date,
+			Author:    n.Author,
+			Field:     "comment",
+			NewValue:  n.Comment,
+			Permanent: true,
+		})
+	}
+
+	return changes
+}
+
+// webhookAuthorized checks the secret sent with a webhook request, either in
+// the X-Trac-Secret header or in the secret query parameter
+func webhookAuthorized(r *http.Request, secret string) bool {
+	sent := r.Header.Get("X-Trac-Secret")
+
+	if len(sent) == 0 {
+		sent = r.URL.Query().Get("secret")
+	}
+
+	return len(secret) > 0 && subtle.ConstantTimeCompare([]byte(sent), []byte(secret)) == 1
+}
+
+// handleWebhook receives ticket notifications posted to /webhook/<trac ID>
+// and posts them in the subscribed channels using the ticket template
+func (b *Bot) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/webhook/"))
+	client := b.tracs[id]
+	secret := b.conf.Tracs[makeTracIds(b.conf.Tracs)[id]].WebhookSecret
+
+	if client == nil || len(secret) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !webhookAuthorized(r, secret) {
+		http.Error(w, "Invalid secret", http.StatusUnauthorized)
+		return
+	}
+
+	var notification ticketNotification
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&notification); err != nil {
+		http.Error(w, "Invalid JSON payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ticketId, err := notification.ticketID()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ticket, err := client.GetTicket(ticketId)
+
+	if err != nil {
+		log.Printf("Error while retrieving notified ticket %s#%s: %s", id, ticketId, err)
+		http.Error(w, "Cannot retrieve ticket", http.StatusBadGateway)
+		return
+	}
+
+	event := trac.TimelineEvent{
+		Kind:     notification.kind(),
+		Author:   notification.Author,
+		Date:     time.Now(),
+		TicketID: ticketId,
+		URL:      ticket["_url"],
+	}
+
+	_, publicErr := b.publicTracs[id].GetTicket(ticketId)
+	changes := notification.ticketChanges(event.Date)
+
+	for _, name := range b.subscribedChannels(id, event, ticket) {
+		message := &reply{}
+
+		channelConfig, _ := b.channelConfig(name)
+
+		if restrictsTickets(channelConfig) && publicErr != nil {
+			fmt.Fprintf(message, ":lock: [Ticket #%s](%s) was updated", ticketId, event.URL)
+		} else if err := b.formatTicket(message, id, ticket, changes, len(changes)); err != nil {
+			log.Printf("Error while formatting notified ticket: %s", err)
+			continue
+		}
+
+		b.postInChannel(name, id, message)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,107 @@
+package bot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abustany/mattermost-trac-bot/config"
+	"github.com/abustany/mattermost-trac-bot/trac"
+)
+
+func decodeNotification(t *testing.T, payload string) ticketNotification {
+	var n ticketNotification
+
+	decoder := json.NewDecoder(strings.NewReader(payload))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&n); err != nil {
+		t.Fatalf("Error while decoding notification: %s", err)
+	}
+
+	return n
+}
+
+func TestTicketNotification(t *testing.T) {
+	n := decodeNotification(t, `{
+		"ticket": 35,
+		"author": "alice",
+		"comment": "Fixed in r1200",
+		"changes": {"status": {"old": "new", "new": "closed"}, "resolution": {"new": "fixed"}}
+	}`)
+
+	if id, err := n.ticketID(); err != nil || id != "35" {
+		t.Errorf("Unexpected ticket ID: %s (%v)", id, err)
+	}
+
+	if kind := n.kind(); kind != trac.TimelineClosedTicket {
+		t.Errorf("Unexpected event kind: %s", kind)
+	}
+
+	changes := n.ticketChanges(time.Date(2017, 1, 4, 9, 0, 0, 0, time.UTC))
+
+	if len(changes) != 3 || changes[0].Field != "resolution" || changes[1].Field != "status" || changes[2].Field != "comment" {
+		t.Fatalf("Unexpected changes: %v", changes)
+	}
+
+	if changes[2].NewValue != "Fixed in r1200" || changes[2].Author != "alice" {
+		t.Errorf("Unexpected comment: %v", changes[2])
+	}
+
+	n = decodeNotification(t, `{"ticket": "#36", "event": "created"}`)
+
+	if id, err := n.ticketID(); err != nil || id != "36" {
+		t.Errorf("Unexpected ticket ID: %s (%v)", id, err)
+	}
+
+	if kind := n.kind(); kind != trac.TimelineNewTicket {
+		t.Errorf("Unexpected event kind: %s", kind)
+	}
+
+	for _, payload := range []string{`{}`, `{"ticket": "abc"}`, `{"ticket": 1.5}`} {
+		if _, err := decodeNotification(t, payload).ticketID(); err == nil {
+			t.Errorf("ticketID should fail for %s", payload)
+		}
+	}
+}
+
+func TestWebhookAuthentication(t *testing.T) {
+	b := &Bot{
+		conf: config.Config{Tracs: map[string]config.TracConfig{
+			"Trac1": {WebhookSecret: "s3cret"},
+			"trac2": {},
+		}},
+		tracs: map[string]*trac.Client{"trac1": {}, "trac2": {}},
+	}
+
+	for _, test := range []struct {
+		method string
+		url    string
+		secret string
+		status int
+	}{
+		{"GET", "/webhook/trac1", "s3cret", http.StatusMethodNotAllowed},
+		{"POST", "/webhook/trac2", "", http.StatusNotFound},
+		{"POST", "/webhook/trac3", "s3cret", http.StatusNotFound},
+		{"POST", "/webhook/trac1", "wrong", http.StatusUnauthorized},
+		{"POST", "/webhook/trac1?secret=wrong", "", http.StatusUnauthorized},
+		{"POST", "/webhook/trac1?secret=s3cret", "", http.StatusBadRequest},
+		{"POST", "/webhook/trac1", "s3cret", http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(test.method, test.url, strings.NewReader(`{"ticket": "x"}`))
+
+		if len(test.secret) > 0 {
+			req.Header.Set("X-Trac-Secret", test.secret)
+		}
+
+		w := httptest.NewRecorder()
+		b.handleWebhook(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("Unexpected status for %s %s: %d, expected %d", test.method, test.url, w.Code, test.status)
+		}
+	}
+}
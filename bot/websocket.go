@@ -0,0 +1,348 @@
+package bot
+
+import (
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/platform/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Interval between two pings on the WebSocket connection, which is closed
+	// if the server did not answer any of the last two
+	websocketPingInterval = 30 * time.Second
+
+	// Bounds of the delay between two reconnection attempts
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 5 * time.Minute
+)
+
+// nextReconnectDelay doubles the delay before the next reconnection attempt,
+// up to maxReconnectDelay
+func nextReconnectDelay(delay time.Duration) time.Duration {
+	if delay *= 2; delay > maxReconnectDelay {
+		return maxReconnectDelay
+	}
+
+	return delay
+}
+
+// jitter returns a random duration between delay/2 and delay, so that
+// several bots do not all reconnect at the same time
+func jitter(delay time.Duration) time.Duration {
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// handleWebSocket handles the events of the Mattermost server until the bot
+// is closed, reconnecting with an exponential backoff when the connection is
+// lost
+func (b *Bot) handleWebSocket() error {
+	if !strings.HasPrefix(b.conf.Server, "http") || len(b.conf.Server) < 5 {
+		return errors.Errorf("Server URL is not HTTP?!")
+	}
+
+	delay := minReconnectDelay
+
+	for {
+		connected, err := b.listenWebSocket()
+
+		select {
+		case <-b.quit:
+			return nil
+		default:
+		}
+
+		if connected {
+			delay = minReconnectDelay
+		}
+
+		log.Printf("WebSocket connection lost: %v", err)
+
+		for {
+			wait := jitter(delay)
+			delay = nextReconnectDelay(delay)
+
+			log.Printf("Reconnecting in %s", wait)
+
+			select {
+			case <-b.quit:
+				return nil
+			case <-time.After(wait):
+			}
+
+			if err := b.resync(); err != nil {
+				log.Printf("Error while reconnecting: %s", err)
+				continue
+			}
+
+			break
+		}
+	}
+}
+
+// resync restores the session after a connection loss, logging in again if
+// the token expired, and reloads the channels
+func (b *Bot) resync() error {
+	if _, err := b.client.GetMe(""); err != nil {
+		if err.StatusCode != http.StatusUnauthorized {
+			return errors.Wrap(err, "Error while checking the session")
+		}
+
+		log.Printf("Session expired, logging in again")
+
+		if err := b.login(); err != nil {
+			return err
+		}
+	}
+
+	return errors.Wrap(b.loadChannels(), "Error while reloading channels")
+}
+
+// listenWebSocket opens a WebSocket connection and handles its events until
+// it is closed. connected tells whether the connection could be established.
+func (b *Bot) listenWebSocket() (connected bool, err error) {
+	wsUrl := "ws" + b.conf.Server[4:]
+
+	log.Printf("Connecting to %s", wsUrl)
+
+	wsClient, appErr := model.NewWebSocketClient(wsUrl, b.client.AuthToken)
+
+	if appErr != nil {
+		return false, errors.Wrapf(appErr, "Error while establishing connection to %s", wsUrl)
+	}
+
+	b.Lock()
+	select {
+	case <-b.quit:
+		// Closed while connecting
+		b.Unlock()
+		wsClient.Close()
+		return true, nil
+	default:
+	}
+	b.wsClient = wsClient
+	b.Unlock()
+
+	wsClient.Listen()
+
+	// Posts answered by the backfill, which the server might also send on the
+	// new connection
+	var backfilled map[string]bool
+
+	b.Lock()
+	since := b.lastPostAt
+
+	if since == 0 {
+		b.lastPostAt = model.GetMillis()
+	}
+	b.Unlock()
+
+	if since != 0 && b.conf.BackfillMissedPosts {
+		backfilled = b.backfill(since)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go b.pingWebSocket(wsClient, done)
+
+	for ev := range wsClient.EventChannel {
+		b.handleEvent(ev, backfilled)
+	}
+
+	if wsClient.ListenError != nil {
+		return true, wsClient.ListenError
+	}
+
+	return true, errors.New("Connection closed")
+}
+
+// pingWebSocket regularly sends a request on a WebSocket connection, and
+// closes it if the server stops answering. It also consumes the responses,
+// which would otherwise block the connection once their channel is full.
+func (b *Bot) pingWebSocket(wsClient *model.WebSocketClient, done chan struct{}) {
+	ticker := time.NewTicker(websocketPingInterval)
+	defer ticker.Stop()
+
+	lastResponse := time.Now()
+
+	for {
+		select {
+		case <-done:
+			return
+		case _, ok := <-wsClient.ResponseChannel:
+			if !ok {
+				return
+			}
+
+			lastResponse = time.Now()
+		case <-ticker.C:
+			if time.Since(lastResponse) > 2*websocketPingInterval {
+				log.Printf("No answer from the server since %s, closing WebSocket connection", lastResponse.Format(time.RFC3339))
+				wsClient.Close()
+				return
+			}
+
+			wsClient.GetStatuses()
+		}
+	}
+}
+
+// handleEvent handles an event received from the WebSocket, skipping the
+// posts already answered
+func (b *Bot) handleEvent(ev *model.WebSocketEvent, backfilled map[string]bool) {
+	switch ev.Event {
+	case model.WEBSOCKET_EVENT_DIRECT_ADDED:
+		b.addDirectChannel(ev.Broadcast.ChannelId, model.CHANNEL_DIRECT)
+		return
+	case model.WEBSOCKET_EVENT_GROUP_ADDED:
+		b.addDirectChannel(ev.Broadcast.ChannelId, model.CHANNEL_GROUP)
+		return
+	case model.WEBSOCKET_EVENT_USER_ADDED, model.WEBSOCKET_EVENT_USER_REMOVED, model.WEBSOCKET_EVENT_CHANNEL_CREATED, model.WEBSOCKET_EVENT_CHANNEL_UPDATED, model.WEBSOCKET_EVENT_CHANNEL_DELETED:
+		if err := b.handleChannelEvent(ev); err != nil {
+			log.Printf("Error while handling %s event: %s", ev.Event, err)
+		}
+
+		return
+	case model.WEBSOCKET_EVENT_POSTED, model.WEBSOCKET_EVENT_POST_EDITED, model.WEBSOCKET_EVENT_POST_DELETED:
+	default:
+		return
+	}
+
+	// Posts tell the type of their channel, in case its creation was missed
+	if channelType, _ := ev.Data["channel_type"].(string); channelType == model.CHANNEL_DIRECT || channelType == model.CHANNEL_GROUP {
+		b.addDirectChannel(ev.Broadcast.ChannelId, channelType)
+	}
+
+	b.Lock()
+	_, known := b.channelNames[ev.Broadcast.ChannelId]
+	_, direct := b.directChannels[ev.Broadcast.ChannelId]
+	b.Unlock()
+
+	if !known && !direct {
+		return
+	}
+
+	data, _ := ev.Data["post"].(string)
+	post := model.PostFromJson(strings.NewReader(data))
+
+	if post == nil || backfilled[post.Id] {
+		return
+	}
+
+	if ev.Event != model.WEBSOCKET_EVENT_POSTED {
+		if !known || b.ignoredPost(post) {
+			return
+		}
+
+		var err error
+
+		if ev.Event == model.WEBSOCKET_EVENT_POST_EDITED {
+			err = b.handleEditedMessage(post)
+		} else {
+			err = b.handleDeletedMessage(post)
+		}
+
+		if err != nil {
+			log.Printf("Error while handling %s event of post %s: %s", ev.Event, post.Id, err)
+		}
+
+		return
+	}
+
+	b.updateLastPostAt(post.CreateAt)
+
+	b.handlePost(post, direct)
+}
+
+// handlePost answers a post of a configured channel, or of a direct or group
+// message channel
+func (b *Bot) handlePost(post *model.Post, direct bool) {
+	if b.ignoredPost(post) {
+		return
+	}
+
+	var err error
+
+	if direct {
+		err = b.handleDirectMessage(post)
+	} else {
+		err = b.handleMessage(post)
+	}
+
+	if err != nil {
+		log.Printf("Error while handling post %s: %s", post.Id, err)
+	}
+}
+
+// ignoredPost tells whether a post was made by the bot, either with its own
+// account or through an incoming webhook. The replies posted through incoming
+// webhooks carry the ID of the webhook creator, and mention Trac objects which
+// would be answered again.
+func (b *Bot) ignoredPost(post *model.Post) bool {
+	return post.UserId == b.botUser().Id || post.Props[botWebhookProp] != nil
+}
+
+// updateLastPostAt records the creation time of a received post
+func (b *Bot) updateLastPostAt(createAt int64) {
+	b.Lock()
+	defer b.Unlock()
+
+	if createAt > b.lastPostAt {
+		b.lastPostAt = createAt
+	}
+}
+
+// backfill answers the posts of the configured channels created since the
+// given time, while the bot was disconnected, and returns their IDs
+func (b *Bot) backfill(since int64) map[string]bool {
+	b.Lock()
+	channelIds := make([]string, 0, len(b.channelNames))
+
+	for id := range b.channelNames {
+		channelIds = append(channelIds, id)
+	}
+	b.Unlock()
+
+	var posts []*model.Post
+
+	for _, id := range channelIds {
+		res, err := b.client.GetPostsSince(id, since)
+
+		if err != nil {
+			log.Printf("Error while retrieving missed posts of channel %s: %s", id, err)
+			continue
+		}
+
+		// Edited posts are returned too, only new ones are answered
+		for _, post := range res.Data.(*model.PostList).Posts {
+			if post.CreateAt > since && post.DeleteAt == 0 {
+				posts = append(posts, post)
+			}
+		}
+	}
+
+	sort.Slice(posts, func(i, j int) bool {
+		return posts[i].CreateAt < posts[j].CreateAt
+	})
+
+	if len(posts) > 0 {
+		log.Printf("Answering %d posts missed while disconnected", len(posts))
+	}
+
+	answered := make(map[string]bool, len(posts))
+
+	for _, post := range posts {
+		b.updateLastPostAt(post.CreateAt)
+
+		answered[post.Id] = true
+		b.handlePost(post, false)
+	}
+
+	return answered
+}
@@ -0,0 +1,133 @@
+package bot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattermost/platform/model"
+
+	"github.com/abustany/mattermost-trac-bot/config"
+	"github.com/abustany/mattermost-trac-bot/trac"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	delay := minReconnectDelay
+
+	for i := 0; i < 20; i++ {
+		wait := jitter(delay)
+
+		if wait < delay/2 || wait > delay {
+			t.Errorf("Jittered delay %s out of bounds for %s", wait, delay)
+		}
+
+		next := nextReconnectDelay(delay)
+
+		if next > maxReconnectDelay || (next != 2*delay && next != maxReconnectDelay) {
+			t.Errorf("Unexpected delay after %s: %s", delay, next)
+		}
+
+		delay = next
+	}
+
+	if delay != maxReconnectDelay {
+		t.Errorf("Delay should be capped to %s, got %s", maxReconnectDelay, delay)
+	}
+
+	if wait := jitter(time.Duration(0)); wait != 0 {
+		t.Errorf("Unexpected jitter for an empty delay: %s", wait)
+	}
+}
+
+func TestIgnoredPost(t *testing.T) {
+	b := &Bot{user: &model.User{Id: "bot"}}
+
+	testData := []struct {
+		post    model.Post
+		ignored bool
+	}{
+		{model.Post{UserId: "u1", Message: "#12"}, false},
+		{model.Post{UserId: "bot", Message: "#12"}, true},
+		{model.Post{UserId: "u1", Message: "[#12](http://trac/ticket/12)", Props: model.StringInterface{"from_webhook": "true", botWebhookProp: "true"}}, true},
+		{model.Post{UserId: "u1", Message: "Build of #12 failed", Props: model.StringInterface{"from_webhook": "true"}}, false},
+	}
+
+	for _, test := range testData {
+		if ignored := b.ignoredPost(&test.post); ignored != test.ignored {
+			t.Errorf("Unexpected ignored status for post %+v: %v", test.post, ignored)
+		}
+	}
+}
+
+func TestWebhookReplyReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Unexpected request to Trac: %s", r.URL)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client, err := trac.New(server.URL, trac.AuthBasic, false)
+
+	if err != nil {
+		t.Fatalf("Error while creating Trac client: %s", err)
+	}
+
+	b := &Bot{
+		conf: config.Config{Channels: map[string]config.ChannelConfig{
+			"town": {TracInstances: []string{"trac1"}, DefaultTracInstance: "trac1", IncomingWebhook: "http://mattermost/hooks/x"},
+		}},
+		user:         &model.User{Id: "bot"},
+		channelNames: map[string]string{"c1": "town"},
+		tracs:        map[string]*trac.Client{"trac1": client},
+		answered:     newAnsweredPosts(),
+	}
+
+	// A reply of the bot, as posted by the incoming webhook of the channel
+	post := &model.Post{
+		Id:        "p1",
+		ChannelId: "c1",
+		UserId:    "webhook-creator",
+		Message:   "[#12](http://trac/ticket/12) Broken build, see trac1#35 and [1234](http://trac/changeset/1234)",
+		Props:     model.StringInterface{"from_webhook": "true", botWebhookProp: "true"},
+	}
+
+	ev := &model.WebSocketEvent{
+		Event:     model.WEBSOCKET_EVENT_POSTED,
+		Data:      map[string]interface{}{"post": post.ToJson()},
+		Broadcast: &model.WebsocketBroadcast{ChannelId: "c1"},
+	}
+
+	b.handleEvent(ev, nil)
+
+	if _, ok := b.answered.get(post.Id); ok {
+		t.Errorf("The bot answered its own webhook reply")
+	}
+}
+
+func TestUpdateLastPostAt(t *testing.T) {
+	b := &Bot{}
+	done := make(chan struct{})
+
+	// Posts are received on the WebSocket while the backfill runs
+	for i := int64(1); i <= 10; i++ {
+		go func(createAt int64) {
+			b.updateLastPostAt(createAt)
+			done <- struct{}{}
+		}(i)
+	}
+
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+
+	if b.lastPostAt != 10 {
+		t.Errorf("Unexpected last post time: %d", b.lastPostAt)
+	}
+
+	b.updateLastPostAt(5)
+
+	if b.lastPostAt != 10 {
+		t.Errorf("The last post time should not go back: %d", b.lastPostAt)
+	}
+}
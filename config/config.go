@@ -1,9 +1,12 @@
 package config
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
+	"regexp"
 
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
@@ -28,6 +31,69 @@ type TracConfig struct {
 	// - http: HTTP Basic Auth
 	// - form: Trac login form
 	AuthType string `yaml:"auth_type"`
+
+	// How ticket information is retrieved:
+	// - csv (default): CSV exports of the web interface
+	// - xmlrpc: XML-RPC API of TracXMLRPCPlugin, falling back to CSV if the
+	//   plugin is not installed
+	// - jsonrpc: same as xmlrpc, using the JSON-RPC API of the plugin
+	Backend string `yaml:"backend,omitempty"`
+
+	// Trac identity deciding which tickets are public, for the channels
+	// restricting ticket details (see ChannelConfig.RestrictedTickets). The
+	// anonymous user is used if no username is set.
+	PublicUsername string `yaml:"public_username,omitempty"`
+	PublicPassword string `yaml:"public_password,omitempty"`
+
+	// Secret that Trac must send along with the ticket notifications posted
+	// to the webhook endpoint of the bot, /webhook/<trac ID>. The webhook is
+	// disabled if empty.
+	WebhookSecret string `yaml:"webhook_secret,omitempty"`
+
+	// Display name and icon of the replies about this Trac instance, in the
+	// channels replying through an incoming webhook (see
+	// ChannelConfig.IncomingWebhook). The defaults of the webhook are used if
+	// empty.
+	DisplayName string `yaml:"display_name,omitempty"`
+	IconURL     string `yaml:"icon_url,omitempty"`
+
+	// Tickets of this instance are shown as message attachments laid out as
+	// configured here, rather than with ticket_template, if set
+	Attachments *AttachmentConfig `yaml:"attachments,omitempty"`
+}
+
+// AttachmentConfig describes how tickets are shown as message attachments.
+// The title of the attachment is the ticket number and summary, linking to
+// the ticket.
+type AttachmentConfig struct {
+	// Ticket fields shown in the attachment, in this order. Empty fields are
+	// skipped.
+	Fields []AttachmentFieldConfig `yaml:"fields,omitempty"`
+
+	// Colour of the attachment depending on the ticket fields, the first
+	// matching rule wins
+	Colors []AttachmentColorConfig `yaml:"colors,omitempty"`
+}
+
+type AttachmentFieldConfig struct {
+	// Name of the ticket field, eg. owner
+	Field string `yaml:"field"`
+
+	// Title of the attachment field, the name of the ticket field if empty
+	Title string `yaml:"title,omitempty"`
+
+	// Whether the field is short enough to be shown next to other fields
+	Short bool `yaml:"short,omitempty"`
+}
+
+type AttachmentColorConfig struct {
+	// Ticket field and value for which the colour is used, eg. priority and
+	// blocker
+	Field string `yaml:"field"`
+	Value string `yaml:"value"`
+
+	// Colour of the attachment, eg. #ff0000
+	Color string `yaml:"color"`
 }
 
 // ChannelConfig represents the configuration for a given channel. The
@@ -40,10 +106,124 @@ type ChannelConfig struct {
 	// The default Trac instance to query if a bug ID is given without an
 	// explicit Trac ID.
 	DefaultTracInstance string `yaml:"default_trac_instance,omitempty"`
+
+	// Whether tickets can be created from this channel with the "new" command
+	CreateTickets bool `yaml:"create_tickets,omitempty"`
+
+	// Whether tickets can be commented on and modified from this channel with
+	// the "comment" and "set" commands
+	UpdateTickets bool `yaml:"update_tickets,omitempty"`
+
+	// What to do with the tickets that the public identity of their Trac
+	// instance cannot see:
+	// - show (default): show them like the other tickets
+	// - redact: only show their number and link
+	// - direct_message: same as redact, and send their details to the author
+	//   of the message in a direct message
+	// The messages of users who registered a personal Trac account are
+	// answered as with redact when tickets are shown. With redact and
+	// direct_message, wiki pages, changesets and milestones are looked up with
+	// the shared account rather than with personal accounts.
+	RestrictedTickets string `yaml:"restricted_tickets,omitempty"`
+
+	// Trac activity posted in this channel, only available for the channels
+	// listed by name
+	Subscriptions []SubscriptionConfig `yaml:"subscriptions,omitempty"`
+
+	// URL of a Mattermost incoming webhook through which the bot posts in
+	// this channel, rather than with its own account, so that the replies
+	// about each Trac instance can have their own display name and icon
+	IncomingWebhook string `yaml:"incoming_webhook,omitempty"`
+
+	// Whether the reply to a message is updated when the message is edited to
+	// mention other Trac objects. A new reply answering the new references is
+	// posted otherwise.
+	EditReplies bool `yaml:"edit_replies,omitempty"`
+
+	// Whether the replies to a message are kept when the message is deleted.
+	// They are deleted along with it by default, so that no ticket details
+	// remain once the message mentioning the ticket is gone.
+	KeepReplies bool `yaml:"keep_replies,omitempty"`
+
+	// Where the replies to messages are posted:
+	// - root (default): at the root of the channel
+	// - thread: in the thread of the message, starting one if needed
+	// - same_as_trigger: in the thread of the message if it is a thread
+	//   reply, at the root of the channel otherwise
+	ReplyMode string `yaml:"reply_mode,omitempty"`
 }
 
+// ChannelRule gives its settings to the channels it matches, which are served
+// by the bot as soon as it is a member of them. A rule matches channels either
+// by name, or by the directives of their header and purpose.
+type ChannelRule struct {
+	// Glob pattern matching the channel names, eg. support-*
+	NameGlob string `yaml:"name_glob,omitempty"`
+
+	// Regular expression matching the channel names, eg. ^proj-(core|web)$
+	NameRegexp string `yaml:"name_regexp,omitempty"`
+
+	// Whether the rule matches the channels whose header or purpose holds a
+	// "trac:<trac ID>[,<trac ID>...]" directive. The channel may then only
+	// query the listed Trac instances that are in the trac_instances of the
+	// rule, the first one being the default unless default_trac_instance is
+	// listed.
+	HeaderDirective bool `yaml:"header_directive,omitempty"`
+
+	// Settings of the matched channels
+	ChannelConfig `yaml:",inline"`
+}
+
+// SubscriptionConfig selects events of the timeline of a Trac instance to be
+// posted in a channel.
+type SubscriptionConfig struct {
+	// Trac instance whose timeline is followed, it must be allowed from the
+	// channel
+	Trac string `yaml:"trac"`
+
+	// Kinds of events to post: newticket, closedticket, reopenedticket,
+	// editedticket, wiki, changeset or milestone. All events are posted if
+	// empty.
+	Events []string `yaml:"events,omitempty"`
+
+	// Only post ticket events of the tickets whose fields have the given
+	// values, eg. {component: core, owner: alice}. Other events are not
+	// filtered.
+	Filters map[string]string `yaml:"filters,omitempty"`
+}
+
+// TimelineEvents lists the kinds of events that can be subscribed to
+var TimelineEvents = []string{"newticket", "closedticket", "reopenedticket", "editedticket", "wiki", "changeset", "milestone"}
+
+const (
+	ModeWebSocket       = "websocket"
+	ModeOutgoingWebhook = "outgoing_webhook"
+)
+
+const (
+	ReplyModeRoot          = "root"
+	ReplyModeThread        = "thread"
+	ReplyModeSameAsTrigger = "same_as_trigger"
+)
+
+const (
+	RestrictedTicketsShow          = "show"
+	RestrictedTicketsRedact        = "redact"
+	RestrictedTicketsDirectMessage = "direct_message"
+)
+
 // Config is the main configuration of the Mattermost bot.
 type Config struct {
+	// How the bot receives the messages:
+	// - websocket (default): the bot logs in to the Mattermost server and
+	//   listens to the channels it is a member of
+	// - outgoing_webhook: Mattermost outgoing webhooks post the messages to
+	//   the /outgoing endpoint of the bot, which answers in the response.
+	//   The bot does not log in, so the features posting on their own
+	//   (subscriptions, ticket notifications, direct messages) are not
+	//   available.
+	Mode string `yaml:"mode,omitempty"`
+
 	// URL of the Mattermost server, eg. http://server.domain:8080
 	Server string `yaml:"server"`
 
@@ -56,16 +236,161 @@ type Config struct {
 	// Team of the bot on the Mattermost server
 	Team string `yaml:"team"`
 
+	// Whether to answer the messages posted in the channels while the bot was
+	// disconnected from the Mattermost server, once it reconnects
+	BackfillMissedPosts bool `yaml:"backfill_missed_posts,omitempty"`
+
 	// Go template (see the doc of template/text) for formatting ticket information
 	TicketTemplate string `yaml:"ticket_template"`
 
+	// Number of latest ticket changes passed to the ticket template. When
+	// greater than 0, the ticket template also receives the latest changes as
+	// .Changes (see trac.TicketChange), the most recent one as .LastChange and
+	// the most recent comment as .LastComment. Retrieving the changelog costs an
+	// extra request per ticket, so it is disabled by default.
+	TicketChanges int `yaml:"ticket_changes,omitempty"`
+
+	// Go template for formatting the results of ticket queries (query: links).
+	// The template receives the query string as .Query, the matching tickets
+	// of the current page as .Tickets, the paging information as .Total, .Page,
+	// .PageCount and .More, and the URL of the full results as .URL.
+	QueryTemplate string `yaml:"query_template,omitempty"`
+
+	// Maximum number of tickets listed in reply to a query (default: 10)
+	QueryMaxResults int `yaml:"query_max_results,omitempty"`
+
+	// Go template for formatting wiki page previews (wiki: links). The
+	// template receives a trac.WikiPage, whose .FirstParagraph method returns
+	// the beginning of the page text.
+	WikiTemplate string `yaml:"wiki_template,omitempty"`
+
+	// Go template for formatting changesets (r1234, [1234], changeset:abc/repo).
+	// The template receives a trac.Changeset, whose .Summary method returns the
+	// first line of the commit message.
+	ChangesetTemplate string `yaml:"changeset_template,omitempty"`
+
+	// Go template for formatting milestone progress (milestone:1.4). The
+	// template receives a trac.Milestone, whose .Open, .Closed, .Total and
+	// .Percent methods summarize the ticket counts found in .Stats.
+	MilestoneTemplate string `yaml:"milestone_template,omitempty"`
+
+	// Go template for formatting the results of saved reports (report:7 or
+	// {7}). The template receives a trac.ReportResult.
+	ReportTemplate string `yaml:"report_template,omitempty"`
+
+	// Maximum number of rows shown in reply to a report (default: 10)
+	ReportMaxRows int `yaml:"report_max_rows,omitempty"`
+
+	// Go template for formatting the results of searches (/trac search). The
+	// template receives the search terms as .Query, the first results as
+	// .Results (see trac.SearchResult) and their total number as .Total. At
+	// most QueryMaxResults results are shown.
+	SearchTemplate string `yaml:"search_template,omitempty"`
+
+	// Go templates for formatting timeline events posted to subscribed
+	// channels, keyed by event kind (see SubscriptionConfig). The "default"
+	// template is used for the kinds without a template. Templates receive a
+	// trac.TimelineEvent, along with the Trac ID as .Trac and, for ticket
+	// events, the ticket fields as .Ticket.
+	TimelineTemplates map[string]string `yaml:"timeline_templates,omitempty"`
+
+	// Interval between two checks of the Trac timelines, in seconds
+	// (default: 60)
+	TimelinePollInterval int `yaml:"timeline_poll_interval,omitempty"`
+
+	// File where the bot keeps track of the last timeline event posted for
+	// each Trac instance, so that no event is lost or posted twice across
+	// restarts. Events are only posted from the bot startup on if not set.
+	StateFile string `yaml:"state_file,omitempty"`
+
+	// Address on which the bot serves its HTTP endpoints, eg. ":8066". No
+	// HTTP server is started if empty.
+	Listen string `yaml:"listen,omitempty"`
+
+	// Token of the Mattermost slash command (eg. /trac) whose requests are
+	// sent to the /command endpoint of the bot. The endpoint is disabled if
+	// empty.
+	SlashCommandToken string `yaml:"slash_command_token,omitempty"`
+
+	// URL at which Mattermost reaches the /action endpoint of the bot, eg.
+	// http://bot.domain:8066/action. When set, the ticket attachments posted
+	// in the channels allowing ticket updates have buttons acting on the
+	// ticket.
+	ActionURL string `yaml:"action_url,omitempty"`
+
+	// Secret signing the context of the buttons, so that the /action endpoint
+	// only acts on the buttons posted by the bot
+	ActionSecret string `yaml:"action_secret,omitempty"`
+
+	// Tokens of the Mattermost outgoing webhooks allowed to post to the
+	// /outgoing endpoint of the bot, used in the outgoing_webhook mode
+	OutgoingWebhookTokens []string `yaml:"outgoing_webhook_tokens,omitempty"`
+
+	// File where the Trac credentials registered by users are stored. When
+	// set, users can send their Trac username and password to the bot in a
+	// direct message, and the bot then acts on Trac with their account rather
+	// than with the shared one of the Trac instance.
+	CredentialsFile string `yaml:"credentials_file,omitempty"`
+
+	// Base64 encoded 32 bytes key used to encrypt the credentials file, eg.
+	// the output of "openssl rand -base64 32"
+	CredentialsKey string `yaml:"credentials_key,omitempty"`
+
 	// List of configured Trac servers
 	Tracs map[string]TracConfig `yaml:"tracs"`
 
 	// Per-channel configuration
 	Channels map[string]ChannelConfig `yaml:"channels"`
+
+	// Rules configuring the channels not listed in Channels, the first
+	// matching rule applying
+	ChannelRules []ChannelRule `yaml:"channel_rules,omitempty"`
+
+	// Settings of the direct and group message channels of the bot, which
+	// are not answered if nil. Subscriptions and incoming webhooks are not
+	// available there.
+	DirectMessages *ChannelConfig `yaml:"direct_messages,omitempty"`
 }
 
+// DefaultQueryTemplate is used when no query template is configured
+const DefaultQueryTemplate = `{{if .Tickets}}| Ticket | Summary | Status | Owner |
+|:-------|:--------|:-------|:------|
+{{range .Tickets}}| [#{{.id}}]({{._url}}) | {{.summary}} | {{.status}} | {{.owner}} |
+{{end}}
+{{end}}{{.Total}} tickets matching [{{.Query}}]({{.URL}}){{if .More}}, page {{.Page}}/{{.PageCount}}{{end}}`
+
+const DefaultQueryMaxResults = 10
+
+// DefaultWikiTemplate is used when no wiki template is configured
+const DefaultWikiTemplate = `[{{.Title}}]({{.URL}}){{if .Author}} (version {{.Version}} by {{.Author}}){{end}}
+{{.FirstParagraph}}`
+
+// DefaultChangesetTemplate is used when no changeset template is configured
+const DefaultChangesetTemplate = `[{{.Revision}}{{if .Repository}}/{{.Repository}}{{end}}]({{.URL}}) by {{.Author}}: {{.Summary}} ({{.FilesChanged}} files changed)`
+
+// DefaultMilestoneTemplate is used when no milestone template is configured
+const DefaultMilestoneTemplate = `[Milestone {{.Name}}]({{.URL}}){{if not .Completed.IsZero}} (completed {{.Completed.Format "2006-01-02"}}){{else if not .Due.IsZero}} (due {{.Due.Format "2006-01-02"}}){{end}}: {{.Percent}}% done, {{.Closed}}/{{.Total}} tickets closed{{range .Stats}}
+- {{.Type}}: {{.Open}} open, {{.Closed}} closed{{end}}`
+
+// DefaultReportTemplate is used when no report template is configured. It
+// renders the visible columns of the report as a table, linking tickets.
+const DefaultReportTemplate = `{{if .Rows}}|{{range .Columns}} {{.}} |{{end}}
+|{{range .Columns}}:---|{{end}}
+{{range $row := .Rows}}|{{range $.Columns}} {{if eq . "ticket"}}[#{{index $row .}}]({{index $row "_url"}}){{else}}{{index $row .}}{{end}} |{{end}}
+{{end}}
+{{end}}[Report {{.ID}}]({{.URL}}): {{.Total}} rows{{if .More}}, showing the first {{len .Rows}}{{end}}`
+
+const DefaultReportMaxRows = 10
+
+// DefaultSearchTemplate is used when no search template is configured
+const DefaultSearchTemplate = `{{range .Results}}- [{{.Title}}]({{.URL}}){{if .Author}} by {{.Author}}{{end}}
+{{end}}{{.Total}} results for {{.Query}}`
+
+// DefaultTimelineTemplate is used for the timeline events without a template
+const DefaultTimelineTemplate = `[{{.Title}}]({{.URL}}) by {{.Author}}`
+
+const DefaultTimelinePollInterval = 60
+
 func LoadFromFile(filename string) (Config, error) {
 	fd, err := os.Open(filename)
 
@@ -105,6 +430,54 @@ func Load(r io.Reader) (Config, error) {
 		c.Channels = map[string]ChannelConfig{}
 	}
 
+	if len(c.QueryTemplate) == 0 {
+		c.QueryTemplate = DefaultQueryTemplate
+	}
+
+	if c.QueryMaxResults == 0 {
+		c.QueryMaxResults = DefaultQueryMaxResults
+	}
+
+	if len(c.WikiTemplate) == 0 {
+		c.WikiTemplate = DefaultWikiTemplate
+	}
+
+	if len(c.ChangesetTemplate) == 0 {
+		c.ChangesetTemplate = DefaultChangesetTemplate
+	}
+
+	if len(c.MilestoneTemplate) == 0 {
+		c.MilestoneTemplate = DefaultMilestoneTemplate
+	}
+
+	if len(c.ReportTemplate) == 0 {
+		c.ReportTemplate = DefaultReportTemplate
+	}
+
+	if len(c.SearchTemplate) == 0 {
+		c.SearchTemplate = DefaultSearchTemplate
+	}
+
+	if c.ReportMaxRows == 0 {
+		c.ReportMaxRows = DefaultReportMaxRows
+	}
+
+	if c.TimelineTemplates == nil {
+		c.TimelineTemplates = map[string]string{}
+	}
+
+	if len(c.TimelineTemplates["default"]) == 0 {
+		c.TimelineTemplates["default"] = DefaultTimelineTemplate
+	}
+
+	if len(c.Mode) == 0 {
+		c.Mode = ModeWebSocket
+	}
+
+	if c.TimelinePollInterval == 0 {
+		c.TimelinePollInterval = DefaultTimelinePollInterval
+	}
+
 	if err := checkConfig(&c); err != nil {
 		return Config{}, err
 	}
@@ -113,16 +486,53 @@ func Load(r io.Reader) (Config, error) {
 }
 
 func checkConfig(c *Config) error {
-	if len(c.Server) == 0 {
-		return errors.New("Server field should not be empty")
+	switch c.Mode {
+	case ModeWebSocket:
+		if len(c.Server) == 0 {
+			return errors.New("Server field should not be empty")
+		}
+
+		if len(c.Username) == 0 {
+			return errors.New("Username field should not be empty")
+		}
+
+		if len(c.Team) == 0 {
+			return errors.New("Team field should not be empty")
+		}
+	case ModeOutgoingWebhook:
+		if err := checkOutgoingWebhookConfig(c); err != nil {
+			return err
+		}
+	default:
+		return errors.Errorf("Invalid mode: %s", c.Mode)
+	}
+
+	if c.TicketChanges < 0 {
+		return errors.New("TicketChanges field should not be negative")
+	}
+
+	if c.QueryMaxResults < 0 {
+		return errors.New("QueryMaxResults field should not be negative")
+	}
+
+	if c.ReportMaxRows < 0 {
+		return errors.New("ReportMaxRows field should not be negative")
+	}
+
+	if c.TimelinePollInterval < 0 {
+		return errors.New("TimelinePollInterval field should not be negative")
+	}
+
+	if len(c.SlashCommandToken) > 0 && len(c.Listen) == 0 {
+		return errors.New("Listen field should not be empty when SlashCommandToken is set")
 	}
 
-	if len(c.Username) == 0 {
-		return errors.New("Username field should not be empty")
+	if len(c.ActionURL) > 0 && (len(c.Listen) == 0 || len(c.ActionSecret) == 0) {
+		return errors.New("Listen and ActionSecret fields should not be empty when ActionURL is set")
 	}
 
-	if len(c.Team) == 0 {
-		return errors.New("Team field should not be empty")
+	if len(c.CredentialsFile) > 0 && len(c.CredentialsKey) == 0 {
+		return errors.New("CredentialsKey field should not be empty when CredentialsFile is set")
 	}
 
 	for name, tracConfig := range c.Tracs {
@@ -137,25 +547,201 @@ func checkConfig(c *Config) error {
 		if len(tracConfig.Password) == 0 {
 			return errors.Errorf("Password missing for Trac instance %s", name)
 		}
+
+		if len(tracConfig.WebhookSecret) > 0 && len(c.Listen) == 0 {
+			return errors.Errorf("Webhook secret set for Trac instance %s, but Listen field is empty", name)
+		}
+
+		if tracConfig.Attachments != nil {
+			for _, field := range tracConfig.Attachments.Fields {
+				if len(field.Field) == 0 {
+					return errors.Errorf("Attachment field without a ticket field for Trac instance %s", name)
+				}
+			}
+
+			for _, color := range tracConfig.Attachments.Colors {
+				if len(color.Field) == 0 || len(color.Color) == 0 {
+					return errors.Errorf("Attachment colour rules of Trac instance %s need a field and a colour", name)
+				}
+			}
+		}
 	}
 
 	for name, channelConfig := range c.Channels {
-		if len(channelConfig.TracInstances) == 0 {
-			return errors.Errorf("No Trac instances defined for channel %s", name)
+		if err := checkChannelConfig(c, "channel "+name, channelConfig); err != nil {
+			return err
 		}
+	}
 
-		for _, trac := range channelConfig.TracInstances {
-			if _, ok := c.Tracs[trac]; !ok {
-				return errors.Errorf("Trac instance %s referred from channel %s does not exist", trac, name)
-			}
+	for i, rule := range c.ChannelRules {
+		if err := checkChannelRule(c, i+1, rule); err != nil {
+			return err
 		}
+	}
 
-		if len(channelConfig.DefaultTracInstance) > 0 {
-			if _, ok := c.Tracs[channelConfig.DefaultTracInstance]; !ok {
-				return errors.Errorf("Default Trac instance %s referred from channel %s does not exist", channelConfig.DefaultTracInstance, name)
+	if dm := c.DirectMessages; dm != nil {
+		if err := checkChannelConfig(c, "direct messages", *dm); err != nil {
+			return err
+		}
+
+		if len(dm.Subscriptions) > 0 || len(dm.IncomingWebhook) > 0 {
+			return errors.New("Subscriptions and incoming webhooks are not available in direct messages")
+		}
+	}
+
+	return nil
+}
+
+// checkChannelConfig checks the configuration of a channel, described by name
+// in error messages
+func checkChannelConfig(c *Config, name string, channelConfig ChannelConfig) error {
+	if len(channelConfig.TracInstances) == 0 {
+		return errors.Errorf("No Trac instances defined for %s", name)
+	}
+
+	for _, trac := range channelConfig.TracInstances {
+		if _, ok := c.Tracs[trac]; !ok {
+			return errors.Errorf("Trac instance %s referred from %s does not exist", trac, name)
+		}
+	}
+
+	switch channelConfig.RestrictedTickets {
+	case "", RestrictedTicketsShow, RestrictedTicketsRedact, RestrictedTicketsDirectMessage:
+	default:
+		return errors.Errorf("Invalid restricted_tickets value for %s: %s", name, channelConfig.RestrictedTickets)
+	}
+
+	switch channelConfig.ReplyMode {
+	case "", ReplyModeRoot:
+	case ReplyModeThread, ReplyModeSameAsTrigger:
+		// Incoming webhooks can only post at the root of the channel
+		if len(channelConfig.IncomingWebhook) > 0 {
+			return errors.Errorf("%s replies through an incoming webhook, it cannot reply in threads", name)
+		}
+	default:
+		return errors.Errorf("Invalid reply_mode value for %s: %s", name, channelConfig.ReplyMode)
+	}
+
+	for _, subscription := range channelConfig.Subscriptions {
+		if !stringSliceContains(channelConfig.TracInstances, subscription.Trac) {
+			return errors.Errorf("Trac instance %s subscribed from %s is not in its trac_instances", subscription.Trac, name)
+		}
+
+		for _, event := range subscription.Events {
+			if !stringSliceContains(TimelineEvents, event) {
+				return errors.Errorf("Unknown timeline event %s subscribed from %s", event, name)
 			}
 		}
 	}
 
+	if len(channelConfig.DefaultTracInstance) > 0 {
+		if _, ok := c.Tracs[channelConfig.DefaultTracInstance]; !ok {
+			return errors.Errorf("Default Trac instance %s referred from %s does not exist", channelConfig.DefaultTracInstance, name)
+		}
+	}
+
 	return nil
 }
+
+// checkChannelRule checks the configuration of the nth channel rule
+func checkChannelRule(c *Config, n int, rule ChannelRule) error {
+	matchers := 0
+
+	if len(rule.NameGlob) > 0 {
+		matchers++
+
+		if _, err := path.Match(rule.NameGlob, ""); err != nil {
+			return errors.Wrapf(err, "Invalid name_glob in channel rule %d", n)
+		}
+	}
+
+	if len(rule.NameRegexp) > 0 {
+		matchers++
+
+		if _, err := regexp.Compile(rule.NameRegexp); err != nil {
+			return errors.Wrapf(err, "Invalid name_regexp in channel rule %d", n)
+		}
+	}
+
+	if rule.HeaderDirective {
+		matchers++
+	}
+
+	if matchers != 1 {
+		return errors.Errorf("Channel rule %d should have exactly one of name_glob, name_regexp and header_directive", n)
+	}
+
+	if err := checkChannelConfig(c, fmt.Sprintf("channel rule %d", n), rule.ChannelConfig); err != nil {
+		return err
+	}
+
+	// Both are tied to a single channel
+	if len(rule.Subscriptions) > 0 || len(rule.IncomingWebhook) > 0 {
+		return errors.Errorf("Subscriptions and incoming webhooks are not available in channel rule %d, list the channel in channels instead", n)
+	}
+
+	return nil
+}
+
+// checkOutgoingWebhookConfig rejects the settings requiring a Mattermost
+// session, which the bot does not have in the outgoing_webhook mode
+func checkOutgoingWebhookConfig(c *Config) error {
+	if len(c.Listen) == 0 {
+		return errors.New("Listen field should not be empty in outgoing_webhook mode")
+	}
+
+	if len(c.OutgoingWebhookTokens) == 0 {
+		return errors.New("OutgoingWebhookTokens field should not be empty in outgoing_webhook mode")
+	}
+
+	if len(c.CredentialsFile) > 0 {
+		return errors.New("User credentials are not available in outgoing_webhook mode")
+	}
+
+	if len(c.ActionURL) > 0 {
+		return errors.New("Ticket buttons are not available in outgoing_webhook mode")
+	}
+
+	for name, tracConfig := range c.Tracs {
+		if len(tracConfig.WebhookSecret) > 0 {
+			return errors.Errorf("Ticket notifications of Trac instance %s are not available in outgoing_webhook mode", name)
+		}
+	}
+
+	for name, channelConfig := range c.Channels {
+		if len(channelConfig.Subscriptions) > 0 {
+			return errors.Errorf("Subscriptions of channel %s are not available in outgoing_webhook mode", name)
+		}
+
+		if channelConfig.RestrictedTickets == RestrictedTicketsDirectMessage {
+			return errors.Errorf("Restricted tickets of channel %s cannot be sent by direct message in outgoing_webhook mode", name)
+		}
+	}
+
+	if c.DirectMessages != nil {
+		return errors.New("Direct messages are not available in outgoing_webhook mode")
+	}
+
+	for i, rule := range c.ChannelRules {
+		// Without a session, the bot cannot read the channel headers
+		if rule.HeaderDirective {
+			return errors.Errorf("Header directives of channel rule %d are not available in outgoing_webhook mode", i+1)
+		}
+
+		if rule.RestrictedTickets == RestrictedTicketsDirectMessage {
+			return errors.Errorf("Restricted tickets of channel rule %d cannot be sent by direct message in outgoing_webhook mode", i+1)
+		}
+	}
+
+	return nil
+}
+
+func stringSliceContains(slice []string, needle string) bool {
+	for _, s := range slice {
+		if s == needle {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,198 @@
+// Package credentials stores the Trac credentials registered by Mattermost
+// users, encrypted on disk.
+package credentials
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Credential is a Trac username with its password (or access token)
+type Credential struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Store holds the credentials of each Mattermost user, for each Trac
+// instance. All changes are immediately written to disk.
+type Store struct {
+	sync.Mutex
+
+	path string
+	aead cipher.AEAD
+
+	// Maps a Mattermost user ID and a normalized Trac ID to credentials
+	credentials map[string]map[string]Credential
+}
+
+// ParseKey decodes a base64 encoded 32 bytes key, as generated by
+// "openssl rand -base64 32"
+func ParseKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while decoding key")
+	}
+
+	if len(key) != 32 {
+		return nil, errors.Errorf("Invalid key length: %d bytes, expected 32", len(key))
+	}
+
+	return key, nil
+}
+
+// Open loads the store saved at path, encrypted with key. The store is empty
+// if the file does not exist yet.
+func Open(path string, key []byte) (*Store, error) {
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while initializing cipher")
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while initializing cipher")
+	}
+
+	s := &Store{
+		path:        path,
+		aead:        aead,
+		credentials: map[string]map[string]Credential{},
+	}
+
+	data, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error while reading %s", path)
+	}
+
+	nonceSize := aead.NonceSize()
+
+	if len(data) < nonceSize {
+		return nil, errors.Errorf("Credentials file %s is truncated", path)
+	}
+
+	plaintext, err := aead.Open(nil, data[0:nonceSize], data[nonceSize:], nil)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error while decrypting %s (wrong key?)", path)
+	}
+
+	if err := json.Unmarshal(plaintext, &s.credentials); err != nil {
+		return nil, errors.Wrapf(err, "Error while decoding %s", path)
+	}
+
+	return s, nil
+}
+
+// Get returns the credentials of a Mattermost user for a Trac instance
+func (s *Store) Get(userId, tracId string) (Credential, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	c, ok := s.credentials[userId][strings.ToLower(tracId)]
+
+	return c, ok
+}
+
+// TracIds returns the Trac instances for which a user registered credentials
+func (s *Store) TracIds(userId string) []string {
+	s.Lock()
+	defer s.Unlock()
+
+	ids := make([]string, 0, len(s.credentials[userId]))
+
+	for id := range s.credentials[userId] {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// Set registers the credentials of a Mattermost user for a Trac instance
+func (s *Store) Set(userId, tracId string, c Credential) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.credentials[userId] == nil {
+		s.credentials[userId] = map[string]Credential{}
+	}
+
+	s.credentials[userId][strings.ToLower(tracId)] = c
+
+	return s.save()
+}
+
+// Remove forgets the credentials of a Mattermost user for a Trac instance
+func (s *Store) Remove(userId, tracId string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.credentials[userId], strings.ToLower(tracId))
+
+	if len(s.credentials[userId]) == 0 {
+		delete(s.credentials, userId)
+	}
+
+	return s.save()
+}
+
+// save encrypts the credentials and atomically replaces the file on disk. The
+// store must be locked.
+func (s *Store) save() error {
+	plaintext, err := json.Marshal(s.credentials)
+
+	if err != nil {
+		return errors.Wrap(err, "Error while encoding credentials")
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return errors.Wrap(err, "Error while generating nonce")
+	}
+
+	data := s.aead.Seal(nonce, nonce, plaintext, nil)
+
+	fd, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path))
+
+	if err != nil {
+		return errors.Wrap(err, "Error while creating temporary credentials file")
+	}
+
+	// TempFile creates files readable by their owner only
+	_, err = io.Copy(fd, bytes.NewReader(data))
+
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(fd.Name(), s.path)
+	}
+
+	if err != nil {
+		os.Remove(fd.Name())
+		return errors.Wrapf(err, "Error while writing %s", s.path)
+	}
+
+	return nil
+}
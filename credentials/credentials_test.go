@@ -0,0 +1,76 @@
+package credentials
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testKey = "q3QxBz0bqfFj8nJ6Y8n4kq9gV6xv0m2cH1yR5s7tU+w="
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+
+	if err != nil {
+		t.Fatalf("Error while creating temporary directory: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	key, err := ParseKey(testKey)
+
+	if err != nil {
+		t.Fatalf("ParseKey failed: %s", err)
+	}
+
+	path := filepath.Join(dir, "credentials")
+	s, err := Open(path, key)
+
+	if err != nil {
+		t.Fatalf("Open failed on missing file: %s", err)
+	}
+
+	if err := s.Set("user1", "Trac1", Credential{"alice", "secret"}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+
+	if err := s.Set("user1", "trac2", Credential{"alice", "secret2"}); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+
+	if err := s.Remove("user1", "trac2"); err != nil {
+		t.Fatalf("Remove failed: %s", err)
+	}
+
+	data, _ := ioutil.ReadFile(path)
+
+	if bytes.Contains(data, []byte("secret")) {
+		t.Errorf("Credentials are stored in clear text")
+	}
+
+	s, err = Open(path, key)
+
+	if err != nil {
+		t.Fatalf("Open failed: %s", err)
+	}
+
+	if c, ok := s.Get("user1", "trac1"); !ok || c != (Credential{"alice", "secret"}) {
+		t.Errorf("Unexpected credentials: %v", c)
+	}
+
+	if _, ok := s.Get("user1", "trac2"); ok {
+		t.Errorf("Removed credentials are still present")
+	}
+
+	otherKey, _ := ParseKey("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+
+	if _, err := Open(path, otherKey); err == nil {
+		t.Errorf("Open should fail with the wrong key")
+	}
+
+	if _, err := ParseKey("c2hvcnQ="); err == nil {
+		t.Errorf("ParseKey should reject short keys")
+	}
+}
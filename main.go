@@ -40,8 +40,15 @@ func main() {
 		log.Fatalf("Error while starting client: %s", err)
 	}
 
+	run := bot.Run
+
+	if conf.Mode == config.ModeOutgoingWebhook {
+		log.Printf("Running in outgoing webhook mode, answering on %s", conf.Listen)
+		run = bot.Serve
+	}
+
 	go func() {
-		errCh <- bot.Run()
+		errCh <- run()
 	}()
 
 	select {
@@ -0,0 +1,127 @@
+package trac
+
+import (
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TicketChange is an entry of a ticket changelog. Comments are reported as
+// changes of the "comment" field, OldValue being the comment number.
+type TicketChange struct {
+	Time      time.Time
+	Author    string
+	Field     string
+	OldValue  string
+	NewValue  string
+	Permanent bool
+}
+
+// GetTicketChangelog returns the changes made to a ticket, oldest first. The
+// RSS feed of the ticket is used if no RPC backend is available, in which case
+// fields are identified by their label rather than by their name.
+func (c *Client) GetTicketChangelog(id string) ([]TicketChange, error) {
+	if c.rpc != nil {
+		changes, err := c.getTicketChangelogRPC(id)
+
+		if errors.Cause(err) != errRPCUnavailable {
+			return changes, err
+		}
+
+		log.Printf("RPC endpoint not available on %s, falling back to RSS feed", c.url)
+	}
+
+	return c.getTicketChangelogRSS(id)
+}
+
+var RSS_FIELD_CHANGE_RE = regexp.MustCompile(`(?s)<li>\s*<strong[^>]*>(.*?)</strong>(.*?)</li>`)
+var RSS_CHANGED_RE = regexp.MustCompile(`(?s)^\s*changed from\s*<em>(.*?)</em>\s*to\s*<em>(.*?)</em>`)
+var RSS_SET_RE = regexp.MustCompile(`(?s)^\s*set to\s*<em>(.*?)</em>`)
+var RSS_DELETED_RE = regexp.MustCompile(`(?s)^\s*<em>(.*?)</em>\s*deleted`)
+var RSS_FIELD_LIST_RE = regexp.MustCompile(`(?s)<ul>.*?</ul>`)
+var RSS_COMMENT_RE = regexp.MustCompile(`#comment:(\d+)$`)
+
+// changesFromRSS extracts the field changes and the comment described by an
+// item of the RSS feed of a ticket
+func changesFromRSS(item rssItem) []TicketChange {
+	var changes []TicketChange
+
+	base := TicketChange{
+		Time:      item.date(),
+		Author:    item.author(),
+		Permanent: true,
+	}
+
+	for _, match := range RSS_FIELD_CHANGE_RE.FindAllStringSubmatch(item.Description, -1) {
+		change := base
+		change.Field = htmlToText(match[1])
+
+		if m := RSS_CHANGED_RE.FindStringSubmatch(match[2]); m != nil {
+			change.OldValue, change.NewValue = htmlToText(m[1]), htmlToText(m[2])
+		} else if m := RSS_SET_RE.FindStringSubmatch(match[2]); m != nil {
+			change.NewValue = htmlToText(m[1])
+		} else if m := RSS_DELETED_RE.FindStringSubmatch(match[2]); m != nil {
+			change.OldValue = htmlToText(m[1])
+		} else {
+			change.NewValue = htmlToText(match[2])
+		}
+
+		changes = append(changes, change)
+	}
+
+	if comment := htmlToText(RSS_FIELD_LIST_RE.ReplaceAllString(item.Description, "")); len(comment) > 0 {
+		change := base
+		change.Field = "comment"
+		change.NewValue = comment
+
+		if m := RSS_COMMENT_RE.FindStringSubmatch(item.Link); m != nil {
+			change.OldValue = m[1]
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes
+}
+
+func (c *Client) getTicketChangelogRSS(id string) ([]TicketChange, error) {
+	if _, err := rpcTicketID(id); err != nil {
+		return nil, err
+	}
+
+	items, err := c.getRSS("/ticket/" + id + "?format=rss")
+
+	if err == errNotFound {
+		return nil, errors.Errorf("Ticket %s does not exist", id)
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error while retrieving changelog of ticket %s", id)
+	}
+
+	var changes []TicketChange
+
+	for _, item := range items {
+		changes = append(changes, changesFromRSS(item)...)
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Time.Before(changes[j].Time) })
+
+	return changes, nil
+}
+
+// LastComment returns the most recent non-empty comment of a changelog, or
+// nil if there is none.
+func LastComment(changes []TicketChange) *TicketChange {
+	for i := len(changes) - 1; i >= 0; i-- {
+		if changes[i].Field == "comment" && len(strings.TrimSpace(changes[i].NewValue)) > 0 {
+			return &changes[i]
+		}
+	}
+
+	return nil
+}
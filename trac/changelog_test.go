@@ -0,0 +1,86 @@
+package trac
+
+import (
+	"testing"
+	"time"
+)
+
+const testTicketRSS = `<?xml version="1.0"?>
+<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/">
+<channel>
+<title>Ticket #35: Panic in backend</title>
+<item>
+<dc:creator>alice</dc:creator>
+<pubDate>Tue, 03 Jan 2017 11:30:00 GMT</pubDate>
+<title>Owner, status changed</title>
+<link>` + testUrl + `/ticket/35#comment:1</link>
+<description>&lt;ul&gt;
+&lt;li&gt;&lt;strong class="trac-field-owner"&gt;Owner&lt;/strong&gt; set to &lt;em&gt;alice&lt;/em&gt;&lt;/li&gt;
+&lt;li&gt;&lt;strong class="trac-field-status"&gt;Status&lt;/strong&gt; changed from &lt;em&gt;new&lt;/em&gt; to &lt;em&gt;assigned&lt;/em&gt;&lt;/li&gt;
+&lt;/ul&gt;</description>
+</item>
+<item>
+<dc:creator>bob</dc:creator>
+<pubDate>Wed, 04 Jan 2017 09:00:00 GMT</pubDate>
+<title>Comment 2</title>
+<link>` + testUrl + `/ticket/35#comment:2</link>
+<description>&lt;ul&gt;
+&lt;li&gt;&lt;strong class="trac-field-keywords"&gt;Keywords&lt;/strong&gt; &lt;em&gt;crash&lt;/em&gt; deleted&lt;/li&gt;
+&lt;/ul&gt;
+&lt;p&gt;
+Still crashing with &lt;tt&gt;-debug&lt;/tt&gt;
+&lt;/p&gt;</description>
+</item>
+</channel>
+</rss>`
+
+func TestGetTicketChangelogRSS(t *testing.T) {
+	s := testServer(t)
+	s.sendPage("/ticket/35?format=rss", testTicketRSS)
+
+	client, err := NewWithHttpClient(testUrl, AuthBasic, false, s)
+
+	if err != nil {
+		t.Fatalf("Error while creating client: %s", err)
+	}
+
+	changes, err := client.GetTicketChangelog("35")
+
+	if err != nil {
+		t.Fatalf("GetTicketChangelog failed: %s", err)
+	}
+
+	first := time.Date(2017, 1, 3, 11, 30, 0, 0, time.UTC)
+	second := time.Date(2017, 1, 4, 9, 0, 0, 0, time.UTC)
+
+	expected := []TicketChange{
+		{first, "alice", "Owner", "", "alice", true},
+		{first, "alice", "Status", "new", "assigned", true},
+		{second, "bob", "Keywords", "crash", "", true},
+		{second, "bob", "comment", "2", "Still crashing with -debug", true},
+	}
+
+	if len(changes) != len(expected) {
+		t.Fatalf("Unexpected changes: %v", changes)
+	}
+
+	for i, change := range expected {
+		if !changes[i].Time.Equal(change.Time) {
+			t.Errorf("Unexpected time for change %d: %s", i, changes[i].Time)
+		}
+
+		changes[i].Time = change.Time
+
+		if changes[i] != change {
+			t.Errorf("Unexpected change %d: %#v", i, changes[i])
+		}
+	}
+
+	if comment := LastComment(changes); comment == nil || comment.Author != "bob" {
+		t.Errorf("Unexpected last comment: %v", comment)
+	}
+
+	if comment := LastComment(changes[0:2]); comment != nil {
+		t.Errorf("Unexpected last comment: %v", comment)
+	}
+}
@@ -0,0 +1,108 @@
+package trac
+
+import (
+	"bytes"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Changeset is a commit of a repository browsed by Trac
+type Changeset struct {
+	// Revision, either a number (Subversion) or a hash (Git, Mercurial)
+	Revision string
+
+	// Name of the repository, empty for the default repository
+	Repository string
+
+	Author       string
+	Date         time.Time
+	Message      string
+	FilesChanged int
+
+	// URL of the changeset in the Trac web interface
+	URL string
+}
+
+// Summary returns the first line of the commit message
+func (c Changeset) Summary() string {
+	return strings.SplitN(strings.TrimSpace(c.Message), "\n", 2)[0]
+}
+
+var CHANGESET_AUTHOR_RE = regexp.MustCompile(`(?s)<dd class="author">(.*?)</dd>`)
+var CHANGESET_MESSAGE_RE = regexp.MustCompile(`(?s)<dd class="message[^"]*">(.*?)</dd>`)
+var CHANGESET_TIME_RE = regexp.MustCompile(`(?s)<dd class="time">.*?[?&](?:amp;)?from=([^&"]+)`)
+var HTML_TAG_RE = regexp.MustCompile(`<[^>]*>`)
+
+// htmlToText strips the tags of an HTML fragment and unescapes its entities
+func htmlToText(s string) string {
+	s = strings.Replace(s, "<br />", "\n", -1)
+	return strings.TrimSpace(html.UnescapeString(HTML_TAG_RE.ReplaceAllString(s, "")))
+}
+
+// changesetPath returns the path to a changeset, relative to the instance URL
+func changesetPath(revision, repository string) string {
+	path := "/changeset/" + url.PathEscape(revision)
+
+	if len(repository) > 0 {
+		path += "/" + url.PathEscape(repository)
+	}
+
+	return path
+}
+
+// GetChangeset retrieves a changeset of the given repository, or of the
+// default repository if repository is empty. TracXMLRPCPlugin does not expose
+// changesets, so they are always read from the web interface: the metadata
+// come from the changeset page and the changed files from its diff export.
+func (c *Client) GetChangeset(revision, repository string) (Changeset, error) {
+	path := changesetPath(revision, repository)
+	changeset := Changeset{
+		Revision:   revision,
+		Repository: repository,
+		URL:        c.url + path,
+	}
+
+	page, err := c.get(path)
+
+	if err == errNotFound {
+		return Changeset{}, errors.Errorf("Changeset %s does not exist", revision)
+	}
+
+	if err != nil {
+		return Changeset{}, errors.Wrapf(err, "Error while retrieving changeset %s", revision)
+	}
+
+	if match := CHANGESET_AUTHOR_RE.FindSubmatch(page); match != nil {
+		changeset.Author = htmlToText(string(match[1]))
+	}
+
+	if match := CHANGESET_MESSAGE_RE.FindSubmatch(page); match != nil {
+		changeset.Message = htmlToText(string(match[1]))
+	}
+
+	if match := CHANGESET_TIME_RE.FindSubmatch(page); match != nil {
+		if from, err := url.QueryUnescape(string(match[1])); err == nil {
+			changeset.Date, _ = time.Parse(time.RFC3339, from)
+		}
+	}
+
+	diff, err := c.get(path + "?format=diff")
+
+	if err != nil {
+		return Changeset{}, errors.Wrapf(err, "Error while retrieving diff of changeset %s", revision)
+	}
+
+	// Trac precedes the diff of each file with an Index: header
+	for _, line := range bytes.Split(diff, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("Index: ")) {
+			changeset.FilesChanged++
+		}
+	}
+
+	return changeset, nil
+}
@@ -0,0 +1,76 @@
+package trac
+
+import (
+	"testing"
+	"time"
+)
+
+const testChangesetPage = `<html><body>
+<dl id="overview">
+  <dt class="property time">Timestamp:</dt>
+  <dd class="time">
+    01/03/17 11:30:00 (<a class="timeline" href="/testPrefix/timeline?from=2017-01-03T11%3A30%3A00Z&amp;precision=second" title="See timeline at 01/03/17 11:30:00">3 hours ago</a>)
+  </dd>
+  <dt class="property author">Author:</dt>
+  <dd class="author"><span class="trac-author">alice</span></dd>
+  <dt class="property message">Message:</dt>
+  <dd class="message searchable">
+    <p>Fix panic in backend &amp; frontend<br />
+</p>
+<p>Refs <a class="new ticket" href="/testPrefix/ticket/35" title="defect: Panic in backend (new)">#35</a>
+</p>
+  </dd>
+</dl>
+</body></html>`
+
+const testChangesetDiff = `Index: backend/main.go
+===================================================================
+--- backend/main.go
++++ backend/main.go
+@@ -1,1 +1,1 @@
+-panic("oops")
++return nil
+Index: frontend/index.html
+===================================================================
+--- frontend/index.html
++++ frontend/index.html
+@@ -1,1 +1,1 @@
+-<p>Index: </p>
++<p>Home</p>
+`
+
+func TestGetChangeset(t *testing.T) {
+	s := testServer(t)
+	s.sendPage("/changeset/1234/backend", testChangesetPage)
+	s.sendPage("/changeset/1234/backend?format=diff", testChangesetDiff)
+
+	client, err := NewWithHttpClient(testUrl, AuthBasic, false, s)
+
+	if err != nil {
+		t.Fatalf("Error while creating client: %s", err)
+	}
+
+	changeset, err := client.GetChangeset("1234", "backend")
+
+	if err != nil {
+		t.Fatalf("GetChangeset failed: %s", err)
+	}
+
+	expected := Changeset{
+		Revision:     "1234",
+		Repository:   "backend",
+		Author:       "alice",
+		Date:         time.Date(2017, 1, 3, 11, 30, 0, 0, time.UTC),
+		Message:      "Fix panic in backend & frontend\n\n\nRefs #35",
+		FilesChanged: 2,
+		URL:          testUrl + "/changeset/1234/backend",
+	}
+
+	if changeset != expected {
+		t.Errorf("Unexpected changeset: %#v", changeset)
+	}
+
+	if summary := changeset.Summary(); summary != "Fix panic in backend & frontend" {
+		t.Errorf("Unexpected summary: %s", summary)
+	}
+}
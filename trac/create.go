@@ -0,0 +1,78 @@
+package trac
+
+import (
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CreateTicket files a new ticket and returns its ID. attributes holds the
+// values of the other ticket fields (type, component...), keyed by field name.
+func (c *Client) CreateTicket(summary, description string, attributes map[string]string) (string, error) {
+	if len(strings.TrimSpace(summary)) == 0 {
+		return "", errors.New("Ticket summary should not be empty")
+	}
+
+	if c.rpc != nil {
+		id, err := c.createTicketRPC(summary, description, attributes)
+
+		if errors.Cause(err) != errRPCUnavailable {
+			return id, err
+		}
+
+		log.Printf("RPC endpoint not available on %s, falling back to web interface", c.url)
+	}
+
+	return c.createTicketWeb(summary, description, attributes)
+}
+
+func (c *Client) createTicketRPC(summary, description string, attributes map[string]string) (string, error) {
+	if attributes == nil {
+		attributes = map[string]string{}
+	}
+
+	res, err := c.rpcCall("ticket.create", summary, description, attributes, true)
+
+	if err != nil {
+		return "", err
+	}
+
+	id, ok := res.(int)
+
+	if !ok {
+		return "", errors.New("Unexpected ticket ID in RPC response")
+	}
+
+	return formatRPCValue(id), nil
+}
+
+var TICKET_LOCATION_RE = regexp.MustCompile(`/ticket/(\d+)(?:[?#].*)?$`)
+
+func (c *Client) createTicketWeb(summary, description string, attributes map[string]string) (string, error) {
+	values := url.Values{
+		"field_summary":     {summary},
+		"field_description": {description},
+		"submit":            {"Create ticket"},
+	}
+
+	for name, value := range attributes {
+		values.Set("field_"+name, value)
+	}
+
+	location, err := c.postForm("/newticket", values)
+
+	if err != nil {
+		return "", errors.Wrap(err, "Error while creating ticket")
+	}
+
+	match := TICKET_LOCATION_RE.FindStringSubmatch(location)
+
+	if match == nil {
+		return "", errors.Errorf("Unexpected redirection after ticket creation: %s", location)
+	}
+
+	return match[1], nil
+}
@@ -0,0 +1,120 @@
+package trac
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+const testNewTicketPage = `<form id="propertyform" action="/testPrefix/newticket" method="post">
+<div><input type="hidden" name="__FORM_TOKEN" value="3f2b8c9d0e1a" /></div>
+</form>`
+
+// submitForm queues a step answering a form POST to path, checking the
+// submitted values. res builds the response.
+func (s *TestServer) submitForm(path string, values map[string]string, res func(req *http.Request) *http.Response) {
+	s.steps = append(s.steps, func(req *http.Request) *http.Response {
+		if req.Method != "POST" || req.URL.String() != testUrl+path {
+			s.t.Errorf("Invalid form submission: %s %s", req.Method, req.URL)
+		}
+
+		if err := req.ParseForm(); err != nil {
+			s.t.Errorf("Invalid form data: %s", err)
+		}
+
+		for name, value := range values {
+			if v := req.PostForm.Get(name); v != value {
+				s.t.Errorf("Unexpected value for form field %s: '%s', expected '%s'", name, v, value)
+			}
+		}
+
+		return res(req)
+	})
+}
+
+func TestCreateTicketXMLRPC(t *testing.T) {
+	s := testServer(t)
+	s.authenticate()
+	s.xmlRPC("ticket.create", []interface{}{
+		"Panic in backend",
+		"Reported from chat",
+		map[string]string{"type": "defect", "component": "core"},
+		true,
+	}, `<?xml version="1.0"?><methodResponse><params><param><value><int>42</int></value></param></params></methodResponse>`)
+
+	client := newXMLRPCTestClient(t, s)
+
+	id, err := client.CreateTicket("Panic in backend", "Reported from chat", map[string]string{"type": "defect", "component": "core"})
+
+	if err != nil {
+		t.Fatalf("CreateTicket failed: %s", err)
+	}
+
+	if id != "42" {
+		t.Errorf("Unexpected ticket ID: %s", id)
+	}
+}
+
+func TestCreateTicketWeb(t *testing.T) {
+	s := testServer(t)
+	s.sendPage("/newticket", testNewTicketPage)
+	s.submitForm("/newticket", map[string]string{
+		"__FORM_TOKEN":      "3f2b8c9d0e1a",
+		"field_summary":     "Panic in backend",
+		"field_description": "",
+		"field_type":        "defect",
+	}, func(req *http.Request) *http.Response {
+		res := makeResponse(http.StatusSeeOther, req)
+		res.Header = http.Header{"Location": {testUrl + "/ticket/42"}}
+
+		return res
+	})
+
+	client, err := NewWithHttpClient(testUrl, AuthBasic, false, s)
+
+	if err != nil {
+		t.Fatalf("Error while creating client: %s", err)
+	}
+
+	id, err := client.CreateTicket("Panic in backend", "", map[string]string{"type": "defect"})
+
+	if err != nil {
+		t.Fatalf("CreateTicket failed: %s", err)
+	}
+
+	if id != "42" {
+		t.Errorf("Unexpected ticket ID: %s", id)
+	}
+}
+
+func TestCreateTicketWebRejected(t *testing.T) {
+	s := testServer(t)
+	s.sendPage("/newticket", testNewTicketPage)
+	s.submitForm("/newticket", nil, func(req *http.Request) *http.Response {
+		res := makeResponse(http.StatusOK, req)
+		res.Body = ioutil.NopCloser(bytes.NewReader([]byte(`<div id="warning" class="system-message warning">
+<strong>Warning:</strong> field component must be set
+</div>` + testNewTicketPage)))
+
+		return res
+	})
+
+	client, err := NewWithHttpClient(testUrl, AuthBasic, false, s)
+
+	if err != nil {
+		t.Fatalf("Error while creating client: %s", err)
+	}
+
+	_, err = client.CreateTicket("Panic in backend", "", nil)
+
+	if err == nil {
+		t.Fatalf("CreateTicket should fail when the form is rejected")
+	}
+
+	const expected = "Error while creating ticket: Warning: field component must be set"
+
+	if err.Error() != expected {
+		t.Errorf("Unexpected error: '%s', expected '%s'", err, expected)
+	}
+}
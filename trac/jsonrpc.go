@@ -0,0 +1,309 @@
+package trac
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// jsonRPCTransport talks to the JSON-RPC endpoint of TracXMLRPCPlugin
+type jsonRPCTransport struct {
+	client *Client
+
+	// ID of the last call, incremented atomically since the client is shared
+	// between goroutines
+	lastID int64
+}
+
+const jsonRPCDateFormat = "2006-01-02T15:04:05"
+
+type jsonRPCCall struct {
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+	ID     int           `json:"id"`
+}
+
+type jsonRPCError struct {
+	Name    string `json:"name"`
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+type jsonRPCResult struct {
+	Result json.RawMessage `json:"result"`
+	Error  *jsonRPCError   `json:"error"`
+	ID     int             `json:"id"`
+}
+
+func (t *jsonRPCTransport) newCall(method string, params []interface{}) (jsonRPCCall, error) {
+	encoded := make([]interface{}, len(params))
+
+	for i, param := range params {
+		v, err := encodeJSONRPCValue(param)
+
+		if err != nil {
+			return jsonRPCCall{}, errors.Wrapf(err, "Error while encoding parameters for %s", method)
+		}
+
+		encoded[i] = v
+	}
+
+	id := atomic.AddInt64(&t.lastID, 1)
+
+	return jsonRPCCall{method, encoded, int(id)}, nil
+}
+
+func (t *jsonRPCTransport) send(call jsonRPCCall) (json.RawMessage, error) {
+	body, err := json.Marshal(call)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while encoding JSON-RPC request")
+	}
+
+	data, err := t.client.rpcPost("jsonrpc", "application/json", body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var res jsonRPCResult
+
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, errors.Wrap(err, "Error while decoding JSON-RPC response")
+	}
+
+	if res.Error != nil {
+		return nil, res.Error.fault()
+	}
+
+	return res.Result, nil
+}
+
+func (t *jsonRPCTransport) call(method string, params ...interface{}) (interface{}, error) {
+	call, err := t.newCall(method, params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := t.send(call)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeJSONRPCValue(res)
+}
+
+func (t *jsonRPCTransport) multicall(requests []rpcRequest) ([]rpcResponse, error) {
+	calls := make([]jsonRPCCall, len(requests))
+	indices := make(map[int]int, len(requests))
+
+	for i, req := range requests {
+		call, err := t.newCall(req.method, req.params)
+
+		if err != nil {
+			return nil, err
+		}
+
+		calls[i] = call
+		indices[call.ID] = i
+	}
+
+	params := make([]interface{}, len(calls))
+
+	for i := range calls {
+		params[i] = calls[i]
+	}
+
+	multicall, err := t.newCall("system.multicall", nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	multicall.Params = params
+
+	data, err := t.send(multicall)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var results []jsonRPCResult
+
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, errors.Wrap(err, "Error while decoding multicall response")
+	}
+
+	responses := make([]rpcResponse, len(requests))
+
+	for _, res := range results {
+		i, ok := indices[res.ID]
+
+		if !ok {
+			return nil, errors.Errorf("Unexpected ID in multicall response: %d", res.ID)
+		}
+
+		delete(indices, res.ID)
+
+		if res.Error != nil {
+			responses[i].err = res.Error.fault()
+			continue
+		}
+
+		if responses[i].value, err = decodeJSONRPCValue(res.Result); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(indices) > 0 {
+		return nil, errors.Errorf("Missing results in multicall response: %d", len(indices))
+	}
+
+	return responses, nil
+}
+
+func (e *jsonRPCError) fault() *RPCFault {
+	return &RPCFault{
+		Code:    e.Code,
+		Message: e.Message,
+	}
+}
+
+// encodeJSONRPCValue converts values which have no JSON representation to the
+// "__jsonclass__" objects understood by Trac.
+func encodeJSONRPCValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil, string, int, int64, bool, float64, []string, map[string]string:
+		return v, nil
+	case time.Time:
+		return map[string]interface{}{
+			"__jsonclass__": []interface{}{"datetime", v.UTC().Format(jsonRPCDateFormat)},
+		}, nil
+	case []byte:
+		return map[string]interface{}{
+			"__jsonclass__": []interface{}{"binary", base64.StdEncoding.EncodeToString(v)},
+		}, nil
+	case []interface{}:
+		values := make([]interface{}, len(v))
+
+		for i, x := range v {
+			encoded, err := encodeJSONRPCValue(x)
+
+			if err != nil {
+				return nil, err
+			}
+
+			values[i] = encoded
+		}
+
+		return values, nil
+	case map[string]interface{}:
+		values := make(map[string]interface{}, len(v))
+
+		for key, x := range v {
+			encoded, err := encodeJSONRPCValue(x)
+
+			if err != nil {
+				return nil, err
+			}
+
+			values[key] = encoded
+		}
+
+		return values, nil
+	default:
+		return nil, errors.Errorf("Cannot encode value of type %T", v)
+	}
+}
+
+func decodeJSONRPCValue(data json.RawMessage) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var v interface{}
+
+	if err := decoder.Decode(&v); err != nil {
+		return nil, errors.Wrap(err, "Error while decoding JSON-RPC value")
+	}
+
+	return convertJSONRPCValue(v)
+}
+
+// convertJSONRPCValue maps decoded JSON values to the types documented in
+// rpcTransport.
+func convertJSONRPCValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return int(n), nil
+		}
+
+		return v.Float64()
+	case []interface{}:
+		for i, x := range v {
+			converted, err := convertJSONRPCValue(x)
+
+			if err != nil {
+				return nil, err
+			}
+
+			v[i] = converted
+		}
+
+		return v, nil
+	case map[string]interface{}:
+		if class, ok := v["__jsonclass__"].([]interface{}); ok && len(class) == 2 {
+			return convertJSONRPCClass(class)
+		}
+
+		for key, x := range v {
+			converted, err := convertJSONRPCValue(x)
+
+			if err != nil {
+				return nil, err
+			}
+
+			v[key] = converted
+		}
+
+		return v, nil
+	default:
+		return v, nil
+	}
+}
+
+func convertJSONRPCClass(class []interface{}) (interface{}, error) {
+	name, _ := class[0].(string)
+	value, _ := class[1].(string)
+
+	switch name {
+	case "datetime":
+		for _, layout := range []string{jsonRPCDateFormat, time.RFC3339, time.RFC3339Nano} {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t, nil
+			}
+		}
+
+		return nil, errors.Errorf("Invalid date: %s", value)
+	case "binary":
+		data, err := base64.StdEncoding.DecodeString(value)
+
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid binary data")
+		}
+
+		return data, nil
+	default:
+		return nil, errors.Errorf("Unknown JSON class: %s", name)
+	}
+}
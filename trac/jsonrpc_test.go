@@ -0,0 +1,240 @@
+package trac
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// jsonRPC queues a step answering a JSON-RPC call to method. The response is
+// built by respond from the decoded request.
+func (s *TestServer) jsonRPC(method string, respond func(req jsonRPCCall) interface{}) {
+	s.steps = append(s.steps, func(req *http.Request) *http.Response {
+		const rpcUrl = testUrl + "/login/jsonrpc"
+
+		if req.URL.String() != rpcUrl {
+			s.t.Errorf("Invalid RPC URL: %s", req.URL)
+		}
+
+		if !s.authenticated {
+			return makeResponse(http.StatusForbidden, req)
+		}
+
+		var call jsonRPCCall
+
+		if err := json.NewDecoder(req.Body).Decode(&call); err != nil {
+			s.t.Errorf("Invalid JSON-RPC request: %s", err)
+			return makeResponse(http.StatusBadRequest, req)
+		}
+
+		if call.Method != method {
+			s.t.Errorf("Unexpected RPC method %s, expected %s", call.Method, method)
+		}
+
+		body, _ := json.Marshal(respond(call))
+
+		res := makeResponse(http.StatusOK, req)
+		res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		return res
+	})
+}
+
+func jsonRPCTicket(id float64) interface{} {
+	return []interface{}{
+		id,
+		map[string]interface{}{"__jsonclass__": []interface{}{"datetime", "2017-01-02T10:00:00"}},
+		map[string]interface{}{"__jsonclass__": []interface{}{"datetime", "2017-01-03T11:30:00"}},
+		map[string]interface{}{
+			"summary":    "Test ticket",
+			"status":     "new",
+			"changetime": map[string]interface{}{"__jsonclass__": []interface{}{"datetime", "2017-01-03T11:30:00"}},
+		},
+	}
+}
+
+func newJSONRPCTestClient(t *testing.T, s *TestServer) *Client {
+	client, err := NewWithHttpClient(testUrl, AuthBasic, false, s)
+
+	if err != nil {
+		t.Fatalf("Error while creating client: %s", err)
+	}
+
+	client.SetBackend(BackendJSONRPC)
+
+	if err := client.Authenticate(testUsername, testPassword); err != nil {
+		t.Fatalf("Authenticate failed: %s", err)
+	}
+
+	return client
+}
+
+func TestJSONRPCGetTicket(t *testing.T) {
+	s := testServer(t)
+	s.authenticate()
+	s.jsonRPC("ticket.get", func(req jsonRPCCall) interface{} {
+		if len(req.Params) != 1 || req.Params[0] != float64(33) {
+			t.Errorf("Unexpected parameters: %v", req.Params)
+		}
+
+		return map[string]interface{}{"result": jsonRPCTicket(33), "error": nil, "id": req.ID}
+	})
+
+	client := newJSONRPCTestClient(t, s)
+
+	ticket, err := client.GetTicket("33")
+
+	if err != nil {
+		t.Fatalf("GetTicket failed: %s", err)
+	}
+
+	if ticket["id"] != "33" || ticket["summary"] != "Test ticket" || ticket["changetime"] != "2017-01-03 11:30:00" {
+		t.Errorf("Unexpected ticket: %v", ticket)
+	}
+
+	if ticket["_url"] != testUrl+"/ticket/33" {
+		t.Errorf("Unexpected ticket URL: %s", ticket["_url"])
+	}
+}
+
+func TestJSONRPCGetTickets(t *testing.T) {
+	s := testServer(t)
+	s.authenticate()
+	s.jsonRPC("system.multicall", func(req jsonRPCCall) interface{} {
+		results := []interface{}{}
+
+		// Answer in reverse order to check that results are matched by ID
+		for i := len(req.Params) - 1; i >= 0; i-- {
+			call := req.Params[i].(map[string]interface{})
+			id := call["params"].([]interface{})[0].(float64)
+
+			if call["method"] != "ticket.get" {
+				t.Errorf("Unexpected method in multicall: %v", call["method"])
+			}
+
+			if id == 404 {
+				results = append(results, map[string]interface{}{
+					"result": nil,
+					"error":  map[string]interface{}{"name": "JSONRPCError", "code": 404, "message": "Ticket 404 does not exist."},
+					"id":     call["id"],
+				})
+			} else {
+				results = append(results, map[string]interface{}{"result": jsonRPCTicket(id), "error": nil, "id": call["id"]})
+			}
+		}
+
+		return map[string]interface{}{"result": results, "error": nil, "id": req.ID}
+	})
+
+	client := newJSONRPCTestClient(t, s)
+
+	tickets, errs := client.GetTickets([]string{"33", "404", "notanumber", "35"})
+
+	if len(tickets) != 4 || len(errs) != 4 {
+		t.Fatalf("Unexpected number of results: %d tickets, %d errors", len(tickets), len(errs))
+	}
+
+	if errs[0] != nil || tickets[0]["id"] != "33" {
+		t.Errorf("Unexpected first result: %v, %v", tickets[0], errs[0])
+	}
+
+	if errs[1] == nil {
+		t.Errorf("Retrieving ticket 404 should have failed")
+	}
+
+	if errs[2] == nil {
+		t.Errorf("Retrieving an invalid ticket ID should have failed")
+	}
+
+	if errs[3] != nil || tickets[3]["id"] != "35" {
+		t.Errorf("Unexpected last result: %v, %v", tickets[3], errs[3])
+	}
+}
+
+func TestJSONRPCSearch(t *testing.T) {
+	s := testServer(t)
+	s.authenticate()
+	s.jsonRPC("search.performSearch", func(req jsonRPCCall) interface{} {
+		if len(req.Params) != 2 || req.Params[0] != "panic" {
+			t.Errorf("Unexpected parameters: %v", req.Params)
+		}
+
+		return map[string]interface{}{
+			"result": []interface{}{
+				[]interface{}{
+					testPath + "/ticket/35",
+					"#35: Panic in backend",
+					map[string]interface{}{"__jsonclass__": []interface{}{"datetime", "2017-01-03T11:30:00"}},
+					"alice",
+					"The backend panics when...",
+				},
+			},
+			"error": nil,
+			"id":    req.ID,
+		}
+	})
+
+	client := newJSONRPCTestClient(t, s)
+
+	results, err := client.Search("panic", []string{"ticket"})
+
+	if err != nil {
+		t.Fatalf("Search failed: %s", err)
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("Unexpected number of results: %d", len(results))
+	}
+
+	expected := SearchResult{
+		URL:     testUrl + "/ticket/35",
+		Title:   "#35: Panic in backend",
+		Date:    time.Date(2017, 1, 3, 11, 30, 0, 0, time.UTC),
+		Author:  "alice",
+		Excerpt: "The backend panics when...",
+	}
+
+	if results[0] != expected {
+		t.Errorf("Unexpected search result: %v", results[0])
+	}
+}
+
+func TestJSONRPCConcurrentCallIDs(t *testing.T) {
+	transport := &jsonRPCTransport{}
+	ids := make(chan int, 100)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < cap(ids); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			call, err := transport.newCall("ticket.get", []interface{}{33})
+
+			if err != nil {
+				t.Errorf("newCall failed: %s", err)
+			}
+
+			ids <- call.ID
+		}()
+	}
+
+	wg.Wait()
+	close(ids)
+
+	seen := map[int]bool{}
+
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("Call ID %d used twice", id)
+		}
+
+		seen[id] = true
+	}
+}
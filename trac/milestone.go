@@ -0,0 +1,316 @@
+package trac
+
+import (
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MilestoneTypeStats counts the tickets of a given type in a milestone
+type MilestoneTypeStats struct {
+	Type   string
+	Open   int
+	Closed int
+}
+
+// Milestone holds the progress of a Trac milestone
+type Milestone struct {
+	Name        string
+	Description string
+
+	// Due date, zero if the milestone has none
+	Due time.Time
+
+	// Completion date, zero if the milestone is not completed. Only available
+	// with an RPC backend.
+	Completed time.Time
+
+	// Ticket counts per ticket type, sorted by type
+	Stats []MilestoneTypeStats
+
+	// URL of the milestone in the Trac web interface
+	URL string
+}
+
+func (m Milestone) Open() int {
+	n := 0
+
+	for _, s := range m.Stats {
+		n += s.Open
+	}
+
+	return n
+}
+
+func (m Milestone) Closed() int {
+	n := 0
+
+	for _, s := range m.Stats {
+		n += s.Closed
+	}
+
+	return n
+}
+
+func (m Milestone) Total() int {
+	return m.Open() + m.Closed()
+}
+
+// Percent returns the percentage of closed tickets
+func (m Milestone) Percent() int {
+	if m.Total() == 0 {
+		return 0
+	}
+
+	return m.Closed() * 100 / m.Total()
+}
+
+// GetMilestone retrieves the due date and ticket statistics of a milestone
+func (c *Client) GetMilestone(name string) (Milestone, error) {
+	if len(name) == 0 {
+		return Milestone{}, errors.New("Empty milestone name")
+	}
+
+	if c.rpc != nil {
+		milestone, err := c.getMilestoneRPC(name)
+
+		if errors.Cause(err) != errRPCUnavailable {
+			return milestone, err
+		}
+
+		log.Printf("RPC endpoint not available on %s, falling back to web interface", c.url)
+	}
+
+	return c.getMilestoneWeb(name)
+}
+
+// addTicketStats counts a ticket of the given type and status in stats
+func addTicketStats(stats map[string]*MilestoneTypeStats, ticketType, status string, n int) {
+	s, ok := stats[ticketType]
+
+	if !ok {
+		s = &MilestoneTypeStats{Type: ticketType}
+		stats[ticketType] = s
+	}
+
+	if status == "closed" {
+		s.Closed += n
+	} else {
+		s.Open += n
+	}
+}
+
+func sortedStats(stats map[string]*MilestoneTypeStats) []MilestoneTypeStats {
+	sorted := make([]MilestoneTypeStats, 0, len(stats))
+
+	for _, s := range stats {
+		if s.Open+s.Closed > 0 {
+			sorted = append(sorted, *s)
+		}
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Type < sorted[j].Type })
+
+	return sorted
+}
+
+// escapeQueryValue escapes the characters having a special meaning in the
+// TracQuery language
+func escapeQueryValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "&", `\&`, "|", `\|`).Replace(s)
+}
+
+func (c *Client) getMilestoneRPC(name string) (Milestone, error) {
+	responses, err := c.rpcMulticall([]rpcRequest{
+		{"ticket.milestone.get", []interface{}{name}},
+		{"ticket.type.getAll", nil},
+	})
+
+	if err != nil {
+		return Milestone{}, err
+	}
+
+	for _, res := range responses {
+		if res.err != nil {
+			return Milestone{}, errors.Wrapf(res.err, "Error while retrieving milestone %s", name)
+		}
+	}
+
+	attributes, ok := responses[0].value.(map[string]interface{})
+
+	if !ok {
+		return Milestone{}, errors.New("Unexpected milestone structure in RPC response")
+	}
+
+	types, ok := responses[1].value.([]interface{})
+
+	if !ok {
+		return Milestone{}, errors.New("Unexpected ticket types structure in RPC response")
+	}
+
+	milestone := Milestone{
+		Name:        name,
+		Description: formatRPCValue(attributes["description"]),
+		Due:         rpcTime(attributes["due"]),
+		Completed:   rpcTime(attributes["completed"]),
+		URL:         c.url + "/milestone/" + url.PathEscape(name),
+	}
+
+	// Count open and closed tickets of each type in a single round-trip
+	queries := make([]rpcRequest, 0, 2*len(types))
+	prefix := "milestone=" + escapeQueryValue(name) + "&max=0"
+
+	for _, t := range types {
+		typeClause := "&type=" + escapeQueryValue(formatRPCValue(t))
+
+		queries = append(queries,
+			rpcRequest{"ticket.query", []interface{}{prefix + typeClause + "&status!=closed"}},
+			rpcRequest{"ticket.query", []interface{}{prefix + typeClause + "&status=closed"}},
+		)
+	}
+
+	if len(queries) == 0 {
+		return milestone, nil
+	}
+
+	responses, err = c.rpcMulticall(queries)
+
+	if err != nil {
+		return Milestone{}, err
+	}
+
+	stats := map[string]*MilestoneTypeStats{}
+
+	for i, res := range responses {
+		if res.err != nil {
+			return Milestone{}, errors.Wrapf(res.err, "Error while counting tickets of milestone %s", name)
+		}
+
+		ids, _ := res.value.([]interface{})
+		status := "closed"
+
+		if i%2 == 0 {
+			status = "new"
+		}
+
+		addTicketStats(stats, formatRPCValue(types[i/2]), status, len(ids))
+	}
+
+	milestone.Stats = sortedStats(stats)
+
+	return milestone, nil
+}
+
+func (c *Client) getMilestoneWeb(name string) (Milestone, error) {
+	milestone := Milestone{
+		Name: name,
+		URL:  c.url + "/milestone/" + url.PathEscape(name),
+	}
+
+	records, err := c.getCSV("/query?" + url.Values{
+		"milestone": {name},
+		"col":       {"id", "type", "status"},
+		"max":       {"0"},
+		"format":    {"csv"},
+	}.Encode())
+
+	if err != nil {
+		return Milestone{}, errors.Wrapf(err, "Error while retrieving tickets of milestone %s", name)
+	}
+
+	if len(records) == 0 {
+		return Milestone{}, errors.New("Missing header in CSV")
+	}
+
+	typeIdx, statusIdx := -1, -1
+
+	for idx, field := range records[0] {
+		switch field {
+		case "type":
+			typeIdx = idx
+		case "status":
+			statusIdx = idx
+		}
+	}
+
+	if typeIdx < 0 || statusIdx < 0 {
+		return Milestone{}, errors.New("Missing type or status column in CSV")
+	}
+
+	stats := map[string]*MilestoneTypeStats{}
+
+	for _, record := range records[1:] {
+		if len(record) != len(records[0]) {
+			return Milestone{}, errors.New("Unexpected number of fields in CSV record")
+		}
+
+		addTicketStats(stats, record[typeIdx], record[statusIdx], 1)
+	}
+
+	milestone.Stats = sortedStats(stats)
+
+	// The due date is only exported in the iCalendar version of the roadmap
+	calendar, err := c.get("/roadmap?show=all&format=ics")
+
+	if err != nil {
+		log.Printf("Error while retrieving roadmap: %s", err)
+	} else {
+		milestone.Due = milestoneDueFromICS(string(calendar), name)
+	}
+
+	if milestone.Due.IsZero() && milestone.Total() == 0 {
+		return Milestone{}, errors.Errorf("Milestone %s does not exist or has no tickets", name)
+	}
+
+	return milestone, nil
+}
+
+// milestoneDueFromICS looks up the due date of a milestone in the iCalendar
+// export of the Trac roadmap.
+func milestoneDueFromICS(calendar string, name string) time.Time {
+	// Long lines are folded, continuation lines starting with a space
+	calendar = strings.Replace(calendar, "\r\n", "\n", -1)
+	calendar = strings.Replace(calendar, "\n ", "", -1)
+
+	var due time.Time
+	var inEvent, found bool
+
+	for _, line := range strings.Split(calendar, "\n") {
+		idx := strings.Index(line, ":")
+
+		if idx < 0 {
+			continue
+		}
+
+		property, value := line[0:idx], line[idx+1:]
+		property = strings.SplitN(property, ";", 2)[0]
+
+		switch {
+		case property == "BEGIN" && value == "VEVENT":
+			inEvent, found, due = true, false, time.Time{}
+		case property == "END" && value == "VEVENT":
+			if inEvent && found {
+				return due
+			}
+
+			inEvent = false
+		case inEvent && property == "DTSTART":
+			for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+				if t, err := time.Parse(layout, value); err == nil {
+					due = t
+					break
+				}
+			}
+		case inEvent && property == "URL":
+			if u, err := url.Parse(value); err == nil && strings.HasSuffix(u.Path, "/milestone/"+name) {
+				found = true
+			}
+		}
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,111 @@
+package trac
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetMilestoneWeb(t *testing.T) {
+	s := testServer(t)
+	s.sendPage("/query?col=id&col=type&col=status&format=csv&max=0&milestone=1.4", "id,type,status\r\n"+
+		"1,defect,closed\r\n"+
+		"2,defect,new\r\n"+
+		"3,enhancement,closed\r\n"+
+		"4,defect,closed\r\n")
+	s.sendPage("/roadmap?show=all&format=ics", "BEGIN:VCALENDAR\r\n"+
+		"BEGIN:VEVENT\r\n"+
+		"UID:</testPrefix/milestone/1.3@127.0.0.1>\r\n"+
+		"DTSTART;VALUE=DATE:20161231\r\n"+
+		"URL:"+testUrl+"/milestone/1.3\r\n"+
+		"END:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\n"+
+		"UID:</testPrefix/milestone/1.4@127.0.0.1>\r\n"+
+		"DTSTART;VALUE=DATE:20170131\r\n"+
+		"SUMMARY:Milestone 1.4\r\n"+
+		"URL:"+testUrl+"/milest\r\n"+
+		" one/1.4\r\n"+
+		"END:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+
+	client, err := NewWithHttpClient(testUrl, AuthBasic, false, s)
+
+	if err != nil {
+		t.Fatalf("Error while creating client: %s", err)
+	}
+
+	milestone, err := client.GetMilestone("1.4")
+
+	if err != nil {
+		t.Fatalf("GetMilestone failed: %s", err)
+	}
+
+	if !milestone.Due.Equal(time.Date(2017, 1, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected due date: %s", milestone.Due)
+	}
+
+	expectedStats := []MilestoneTypeStats{{"defect", 1, 2}, {"enhancement", 0, 1}}
+
+	if len(milestone.Stats) != len(expectedStats) {
+		t.Fatalf("Unexpected stats: %v", milestone.Stats)
+	}
+
+	for i, s := range expectedStats {
+		if milestone.Stats[i] != s {
+			t.Errorf("Unexpected stats for %s: %v", s.Type, milestone.Stats[i])
+		}
+	}
+
+	if milestone.Open() != 1 || milestone.Closed() != 3 || milestone.Percent() != 75 {
+		t.Errorf("Unexpected progress: %d open, %d closed, %d%%", milestone.Open(), milestone.Closed(), milestone.Percent())
+	}
+
+	if milestone.URL != testUrl+"/milestone/1.4" {
+		t.Errorf("Unexpected milestone URL: %s", milestone.URL)
+	}
+}
+
+func TestGetMilestoneRPC(t *testing.T) {
+	s := testServer(t)
+	s.authenticate()
+	s.xmlRPC("system.multicall", []interface{}{[]interface{}{
+		map[string]interface{}{"methodName": "ticket.milestone.get", "params": []interface{}{"1.4"}},
+		map[string]interface{}{"methodName": "ticket.type.getAll", "params": []interface{}{}},
+	}}, `<?xml version='1.0'?>
+<methodResponse><params><param><value><array><data>
+<value><array><data><value><struct>
+<member><name>name</name><value><string>1.4</string></value></member>
+<member><name>due</name><value><dateTime.iso8601>20170131T00:00:00</dateTime.iso8601></value></member>
+<member><name>completed</name><value><int>0</int></value></member>
+<member><name>description</name><value><string>Spring release</string></value></member>
+</struct></value></data></array></value>
+<value><array><data><value><array><data><value>defect</value><value>task</value></data></array></value></data></array></value>
+</data></array></value></param></params></methodResponse>`)
+	s.xmlRPC("system.multicall", []interface{}{[]interface{}{
+		map[string]interface{}{"methodName": "ticket.query", "params": []interface{}{"milestone=1.4&max=0&type=defect&status!=closed"}},
+		map[string]interface{}{"methodName": "ticket.query", "params": []interface{}{"milestone=1.4&max=0&type=defect&status=closed"}},
+		map[string]interface{}{"methodName": "ticket.query", "params": []interface{}{"milestone=1.4&max=0&type=task&status!=closed"}},
+		map[string]interface{}{"methodName": "ticket.query", "params": []interface{}{"milestone=1.4&max=0&type=task&status=closed"}},
+	}}, `<?xml version='1.0'?>
+<methodResponse><params><param><value><array><data>
+<value><array><data><value><array><data><value><int>2</int></value></data></array></value></data></array></value>
+<value><array><data><value><array><data><value><int>1</int></value><value><int>4</int></value></data></array></value></data></array></value>
+<value><array><data><value><array><data></data></array></value></data></array></value>
+<value><array><data><value><array><data></data></array></value></data></array></value>
+</data></array></value></param></params></methodResponse>`)
+
+	client := newXMLRPCTestClient(t, s)
+
+	milestone, err := client.GetMilestone("1.4")
+
+	if err != nil {
+		t.Fatalf("GetMilestone failed: %s", err)
+	}
+
+	if milestone.Description != "Spring release" || !milestone.Completed.IsZero() || milestone.Due.IsZero() {
+		t.Errorf("Unexpected milestone: %v", milestone)
+	}
+
+	if len(milestone.Stats) != 1 || milestone.Stats[0] != (MilestoneTypeStats{"defect", 1, 2}) {
+		t.Errorf("Unexpected stats: %v", milestone.Stats)
+	}
+}
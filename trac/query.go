@@ -0,0 +1,383 @@
+package trac
+
+import (
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// queryOperators maps the operators of the TracQuery language to the prefix
+// used for values when the query is expressed as URL parameters. Longer
+// operators come first so that they get matched first.
+var queryOperators = []struct {
+	operator string
+	prefix   string
+}{
+	{"!~=", "!~"},
+	{"!^=", "!^"},
+	{"!$=", "!$"},
+	{"!=", "!"},
+	{"~=", "~"},
+	{"^=", "^"},
+	{"$=", "$"},
+	{"=", ""},
+}
+
+// queryParameters are the query arguments which are not field constraints
+var queryParameters = map[string]bool{
+	"order":     true,
+	"desc":      true,
+	"group":     true,
+	"groupdesc": true,
+	"col":       true,
+	"verbose":   true,
+	"report":    true,
+	"max":       true,
+	"page":      true,
+	"format":    true,
+}
+
+type queryConstraint struct {
+	field  string
+	prefix string
+	values []string
+}
+
+// ticketQuery is a parsed TracQuery
+type ticketQuery struct {
+	constraints []queryConstraint
+	parameters  url.Values
+}
+
+// parseQuery parses a query expressed either in the TracQuery language
+// (status=new|assigned&owner=alice) or as URL parameters, in which case it
+// starts with a question mark (?status=new&status=assigned&owner=alice).
+func parseQuery(s string) (ticketQuery, error) {
+	if strings.HasPrefix(s, "?") {
+		return parseURLQuery(s[1:])
+	}
+
+	q := ticketQuery{parameters: url.Values{}}
+
+	for _, clause := range strings.Split(s, "&") {
+		if len(clause) == 0 {
+			continue
+		}
+
+		idx := strings.Index(clause, "=")
+
+		if idx <= 0 {
+			return ticketQuery{}, errors.Errorf("Invalid query clause: %s", clause)
+		}
+
+		field, prefix := clause[0:idx], ""
+
+		for _, op := range queryOperators {
+			if strings.HasSuffix(clause[0:idx+1], op.operator) {
+				field, prefix = clause[0:idx+1-len(op.operator)], op.prefix
+				break
+			}
+		}
+
+		if len(field) == 0 {
+			return ticketQuery{}, errors.Errorf("Invalid query clause: %s", clause)
+		}
+
+		values := strings.Split(clause[idx+1:], "|")
+
+		if queryParameters[field] {
+			for _, v := range values {
+				q.parameters.Add(field, v)
+			}
+		} else {
+			q.constraints = append(q.constraints, queryConstraint{field, prefix, values})
+		}
+	}
+
+	return q, nil
+}
+
+func parseURLQuery(s string) (ticketQuery, error) {
+	params, err := url.ParseQuery(s)
+
+	if err != nil {
+		return ticketQuery{}, errors.Wrap(err, "Invalid query parameters")
+	}
+
+	q := ticketQuery{parameters: url.Values{}}
+
+	// Iterate over the raw query to keep the order of the constraints
+	for _, pair := range strings.Split(s, "&") {
+		field, err := url.QueryUnescape(strings.SplitN(pair, "=", 2)[0])
+
+		if err != nil || len(field) == 0 {
+			continue
+		}
+
+		values, ok := params[field]
+
+		if !ok {
+			// Already processed
+			continue
+		}
+
+		delete(params, field)
+
+		if queryParameters[field] {
+			q.parameters[field] = values
+			continue
+		}
+
+		// Values having the same operator are grouped in a single constraint
+		byPrefix := map[string]int{}
+
+		for _, v := range values {
+			prefix := ""
+
+			for _, op := range queryOperators {
+				if len(op.prefix) > 0 && strings.HasPrefix(v, op.prefix) {
+					prefix = op.prefix
+					break
+				}
+			}
+
+			v = v[len(prefix):]
+
+			if idx, ok := byPrefix[prefix]; ok {
+				q.constraints[idx].values = append(q.constraints[idx].values, v)
+			} else {
+				byPrefix[prefix] = len(q.constraints)
+				q.constraints = append(q.constraints, queryConstraint{field, prefix, []string{v}})
+			}
+		}
+	}
+
+	return q, nil
+}
+
+// urlValues returns the query as parameters of the /query page
+func (q ticketQuery) urlValues() url.Values {
+	values := url.Values{}
+
+	for _, c := range q.constraints {
+		for _, v := range c.values {
+			values.Add(c.field, c.prefix+v)
+		}
+	}
+
+	for name, v := range q.parameters {
+		values[name] = append([]string{}, v...)
+	}
+
+	return values
+}
+
+// String returns the query in the TracQuery language
+func (q ticketQuery) String() string {
+	var clauses []string
+
+	for _, c := range q.constraints {
+		operator := "="
+
+		for _, op := range queryOperators {
+			if op.prefix == c.prefix {
+				operator = op.operator
+				break
+			}
+		}
+
+		clauses = append(clauses, c.field+operator+strings.Join(c.values, "|"))
+	}
+
+	for _, name := range []string{"order", "desc", "group", "groupdesc", "col", "max", "page"} {
+		if v, ok := q.parameters[name]; ok {
+			clauses = append(clauses, name+"="+strings.Join(v, "|"))
+		}
+	}
+
+	return strings.Join(clauses, "&")
+}
+
+// QueryResult holds one page of the tickets matching a query
+type QueryResult struct {
+	// Tickets of the requested page
+	Tickets []Ticket
+
+	// Total number of tickets matching the query
+	Total int
+
+	// Current page, starting at 1
+	Page int
+
+	// Number of pages
+	PageCount int
+
+	// URL of the query in the Trac web interface
+	URL string
+}
+
+// More returns whether some matching tickets are not part of the result
+func (r QueryResult) More() bool {
+	return len(r.Tickets) < r.Total
+}
+
+// Query runs a TracQuery and returns the matching tickets. Results are split
+// in pages of at most maxResults tickets; the "max" and "page" parameters of
+// the query can be used to select a smaller page size or another page.
+func (c *Client) Query(query string, maxResults int) (QueryResult, error) {
+	q, err := parseQuery(query)
+
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	if maxResults <= 0 {
+		return QueryResult{}, errors.Errorf("Invalid maximum number of results: %d", maxResults)
+	}
+
+	pageSize := maxResults
+
+	if max, err := strconv.Atoi(q.parameters.Get("max")); err == nil && max > 0 && max < pageSize {
+		pageSize = max
+	}
+
+	page := 1
+
+	if p, err := strconv.Atoi(q.parameters.Get("page")); err == nil && p > 1 {
+		page = p
+	}
+
+	// Pagination is done on our side, we always fetch the full list
+	q.parameters.Del("page")
+	q.parameters.Del("format")
+	q.parameters.Set("max", "0")
+
+	webValues := q.urlValues()
+	webValues.Del("max")
+
+	result := QueryResult{
+		Page: page,
+		URL:  c.url + "/query?" + webValues.Encode(),
+	}
+
+	var tickets []Ticket
+
+	if c.rpc != nil {
+		tickets, result.Total, err = c.queryRPC(q, page, pageSize)
+
+		if errors.Cause(err) == errRPCUnavailable {
+			log.Printf("RPC endpoint not available on %s, falling back to CSV export", c.url)
+		}
+	}
+
+	if c.rpc == nil || errors.Cause(err) == errRPCUnavailable {
+		tickets, result.Total, err = c.queryCSV(q, page, pageSize)
+	}
+
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	result.Tickets = tickets
+	result.PageCount = (result.Total + pageSize - 1) / pageSize
+
+	return result, nil
+}
+
+// pageBounds returns the indices of the first and past-the-last items of page
+func pageBounds(total, page, pageSize int) (int, int) {
+	start := (page - 1) * pageSize
+
+	if start > total {
+		start = total
+	}
+
+	end := start + pageSize
+
+	if end > total {
+		end = total
+	}
+
+	return start, end
+}
+
+func (c *Client) queryRPC(q ticketQuery, page, pageSize int) ([]Ticket, int, error) {
+	res, err := c.rpcCall("ticket.query", q.String())
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	list, ok := res.([]interface{})
+
+	if !ok {
+		return nil, 0, errors.New("Unexpected query result structure in RPC response")
+	}
+
+	start, end := pageBounds(len(list), page, pageSize)
+	ids := make([]string, 0, end-start)
+
+	for _, id := range list[start:end] {
+		ids = append(ids, formatRPCValue(id))
+	}
+
+	if len(ids) == 0 {
+		return nil, len(list), nil
+	}
+
+	tickets, errs, err := c.getTicketsRPC(ids)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "Error while retrieving ticket %s", ids[i])
+		}
+	}
+
+	return tickets, len(list), nil
+}
+
+func (c *Client) queryCSV(q ticketQuery, page, pageSize int) ([]Ticket, int, error) {
+	values := q.urlValues()
+	values.Set("format", "csv")
+
+	records, err := c.getCSV("/query?" + values.Encode())
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(records) == 0 {
+		return nil, 0, errors.New("Missing header in CSV")
+	}
+
+	total := len(records) - 1
+	start, end := pageBounds(total, page, pageSize)
+	tickets := make([]Ticket, 0, end-start)
+
+	for _, record := range records[1+start : 1+end] {
+		if len(record) != len(records[0]) {
+			return nil, 0, errors.New("Unexpected number of fields in CSV record")
+		}
+
+		ticket := Ticket{}
+
+		for idx, field := range records[0] {
+			ticket[field] = record[idx]
+		}
+
+		if id, ok := ticket["id"]; ok {
+			ticket["_url"] = c.url + "/ticket/" + id
+		}
+
+		tickets = append(tickets, ticket)
+	}
+
+	return tickets, total, nil
+}
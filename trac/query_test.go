@@ -0,0 +1,147 @@
+package trac
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+		values   url.Values
+	}{
+		{
+			"status=new|assigned&owner=alice",
+			"status=new|assigned&owner=alice",
+			url.Values{"status": {"new", "assigned"}, "owner": {"alice"}},
+		},
+		{
+			"?status=new&status=assigned&owner=alice",
+			"status=new|assigned&owner=alice",
+			url.Values{"status": {"new", "assigned"}, "owner": {"alice"}},
+		},
+		{
+			"status!=closed&summary~=panic&order=priority&col=id|summary",
+			"status!=closed&summary~=panic&order=priority&col=id|summary",
+			url.Values{"status": {"!closed"}, "summary": {"~panic"}, "order": {"priority"}, "col": {"id", "summary"}},
+		},
+		{
+			"?milestone=1.2&status=!closed&keywords=%5Efoo",
+			"milestone=1.2&status!=closed&keywords^=foo",
+			url.Values{"milestone": {"1.2"}, "status": {"!closed"}, "keywords": {"^foo"}},
+		},
+	}
+
+	for _, test := range tests {
+		q, err := parseQuery(test.query)
+
+		if err != nil {
+			t.Errorf("Error while parsing %s: %s", test.query, err)
+			continue
+		}
+
+		if s := q.String(); s != test.expected {
+			t.Errorf("Unexpected query string for %s: %s, expected %s", test.query, s, test.expected)
+		}
+
+		if values := q.urlValues(); values.Encode() != test.values.Encode() {
+			t.Errorf("Unexpected URL values for %s: %s, expected %s", test.query, values.Encode(), test.values.Encode())
+		}
+	}
+
+	for _, invalid := range []string{"status", "=new", "status=new&!=closed"} {
+		if _, err := parseQuery(invalid); err == nil {
+			t.Errorf("Parsing %s should have failed", invalid)
+		}
+	}
+}
+
+func (s *TestServer) sendQuery(expectedUrl string) {
+	s.steps = append(s.steps, func(req *http.Request) *http.Response {
+		if req.URL.String() != expectedUrl {
+			s.t.Errorf("Invalid query URL: %s", req.URL)
+		}
+
+		const csv = "\xef\xbb\xbfid,summary,status\r\n" +
+			"1,First,new\r\n" +
+			"2,Second,new\r\n" +
+			"3,Third,assigned\r\n"
+
+		res := makeResponse(http.StatusOK, req)
+		res.Body = ioutil.NopCloser(bytes.NewReader([]byte(csv)))
+
+		return res
+	})
+}
+
+func TestQueryCSV(t *testing.T) {
+	s := testServer(t)
+	s.sendQuery(testUrl + "/query?format=csv&max=0&owner=alice&status=new&status=assigned")
+	s.sendQuery(testUrl + "/query?format=csv&max=0&owner=alice&status=new&status=assigned")
+
+	client, err := NewWithHttpClient(testUrl, AuthBasic, false, s)
+
+	if err != nil {
+		t.Fatalf("Error while creating client: %s", err)
+	}
+
+	res, err := client.Query("status=new|assigned&owner=alice", 2)
+
+	if err != nil {
+		t.Fatalf("Query failed: %s", err)
+	}
+
+	if res.Total != 3 || res.Page != 1 || res.PageCount != 2 || !res.More() {
+		t.Errorf("Unexpected query result: %v", res)
+	}
+
+	if len(res.Tickets) != 2 || res.Tickets[1]["summary"] != "Second" || res.Tickets[1]["_url"] != testUrl+"/ticket/2" {
+		t.Errorf("Unexpected tickets: %v", res.Tickets)
+	}
+
+	if res.URL != testUrl+"/query?owner=alice&status=new&status=assigned" {
+		t.Errorf("Unexpected query URL: %s", res.URL)
+	}
+
+	res, err = client.Query("?status=new&status=assigned&owner=alice&page=2", 2)
+
+	if err != nil {
+		t.Fatalf("Query failed: %s", err)
+	}
+
+	if res.Page != 2 || len(res.Tickets) != 1 || res.Tickets[0]["id"] != "3" {
+		t.Errorf("Unexpected second page: %v", res)
+	}
+}
+
+func TestQueryRPC(t *testing.T) {
+	s := testServer(t)
+	s.authenticate()
+	s.xmlRPC("ticket.query", []interface{}{"status!=closed&max=0"}, `<?xml version='1.0'?>
+<methodResponse><params><param><value><array><data>
+<value><int>12</int></value>
+<value><int>33</int></value>
+</data></array></value></param></params></methodResponse>`)
+	s.xmlRPC("system.multicall", []interface{}{[]interface{}{
+		map[string]interface{}{"methodName": "ticket.get", "params": []interface{}{33}},
+	}}, `<?xml version='1.0'?>
+<methodResponse><params><param><value><array><data>
+<value><array><data>`+xmlRPCTicketValue+`</data></array></value>
+</data></array></value></param></params></methodResponse>`)
+
+	client := newXMLRPCTestClient(t, s)
+
+	res, err := client.Query("status!=closed&page=2", 1)
+
+	if err != nil {
+		t.Fatalf("Query failed: %s", err)
+	}
+
+	if res.Total != 2 || res.PageCount != 2 || len(res.Tickets) != 1 || res.Tickets[0]["id"] != "33" {
+		t.Errorf("Unexpected query result: %v", res)
+	}
+}
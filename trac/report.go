@@ -0,0 +1,93 @@
+package trac
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ReportResult holds the first rows of a saved Trac report
+type ReportResult struct {
+	// Number of the report
+	ID string
+
+	// Visible columns of the report, in order. Trac hides columns whose name
+	// starts with an underscore, they are still available in the rows.
+	Columns []string
+
+	// First rows of the report. If the report has a "ticket" or "id" column,
+	// the URL of the ticket is stored in the "_url" member.
+	Rows []Ticket
+
+	// Total number of rows of the report
+	Total int
+
+	// URL of the report in the Trac web interface
+	URL string
+}
+
+// More returns whether some rows of the report are not part of the result
+func (r ReportResult) More() bool {
+	return len(r.Rows) < r.Total
+}
+
+// RunReport runs a saved report and returns at most maxRows of its rows
+func (c *Client) RunReport(id string, maxRows int) (ReportResult, error) {
+	if maxRows <= 0 {
+		return ReportResult{}, errors.Errorf("Invalid maximum number of rows: %d", maxRows)
+	}
+
+	path := "/report/" + url.PathEscape(id)
+
+	records, err := c.getCSV(path + "?format=csv")
+
+	if err == errNotFound {
+		return ReportResult{}, errors.Errorf("Report %s does not exist", id)
+	}
+
+	if err != nil {
+		return ReportResult{}, errors.Wrapf(err, "Error while running report %s", id)
+	}
+
+	if len(records) == 0 {
+		return ReportResult{}, errors.New("Missing header in CSV")
+	}
+
+	result := ReportResult{
+		ID:    id,
+		Total: len(records) - 1,
+		URL:   c.url + path,
+	}
+
+	for _, field := range records[0] {
+		if !strings.HasPrefix(field, "_") {
+			result.Columns = append(result.Columns, field)
+		}
+	}
+
+	_, end := pageBounds(result.Total, 1, maxRows)
+
+	for _, record := range records[1 : 1+end] {
+		if len(record) != len(records[0]) {
+			return ReportResult{}, errors.New("Unexpected number of fields in CSV record")
+		}
+
+		row := Ticket{}
+
+		for idx, field := range records[0] {
+			row[field] = record[idx]
+		}
+
+		for _, idField := range []string{"ticket", "id"} {
+			if id, ok := row[idField]; ok && len(id) > 0 {
+				row["_url"] = c.url + "/ticket/" + id
+				break
+			}
+		}
+
+		result.Rows = append(result.Rows, row)
+	}
+
+	return result, nil
+}
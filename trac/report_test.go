@@ -0,0 +1,46 @@
+package trac
+
+import (
+	"testing"
+)
+
+func TestRunReport(t *testing.T) {
+	s := testServer(t)
+	s.sendPage("/report/7?format=csv", "\xef\xbb\xbf__color__,ticket,summary,owner,_description\r\n"+
+		"1,35,Panic in backend,alice,Long text\r\n"+
+		"3,36,Typo,bob,\r\n"+
+		"2,40,Crash,,\r\n")
+	s.notFound()
+
+	client, err := NewWithHttpClient(testUrl, AuthBasic, false, s)
+
+	if err != nil {
+		t.Fatalf("Error while creating client: %s", err)
+	}
+
+	report, err := client.RunReport("7", 2)
+
+	if err != nil {
+		t.Fatalf("RunReport failed: %s", err)
+	}
+
+	if len(report.Columns) != 3 || report.Columns[0] != "ticket" || report.Columns[2] != "owner" {
+		t.Errorf("Unexpected columns: %v", report.Columns)
+	}
+
+	if report.Total != 3 || len(report.Rows) != 2 || !report.More() {
+		t.Errorf("Unexpected number of rows: %d/%d", len(report.Rows), report.Total)
+	}
+
+	if report.Rows[1]["summary"] != "Typo" || report.Rows[1]["_url"] != testUrl+"/ticket/36" || report.Rows[1]["__color__"] != "3" {
+		t.Errorf("Unexpected row: %v", report.Rows[1])
+	}
+
+	if report.URL != testUrl+"/report/7" {
+		t.Errorf("Unexpected report URL: %s", report.URL)
+	}
+
+	if _, err := client.RunReport("99", 2); err == nil || err.Error() != "Report 99 does not exist" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,547 @@
+package trac
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Backend selects how ticket data is retrieved from a Trac instance.
+type Backend uint
+
+const (
+	// Scrape the CSV exports of the web interface
+	BackendCSV Backend = iota
+
+	// Use the XML-RPC flavour of TracXMLRPCPlugin
+	BackendXMLRPC
+
+	// Use the JSON-RPC flavour of TracXMLRPCPlugin
+	BackendJSONRPC
+)
+
+func ParseBackend(s string) (Backend, error) {
+	s = strings.ToLower(s)
+
+	switch s {
+	case "", "csv":
+		return BackendCSV, nil
+	case "xmlrpc":
+		return BackendXMLRPC, nil
+	case "jsonrpc":
+		return BackendJSONRPC, nil
+	default:
+		return BackendCSV, errors.Errorf("Invalid Backend string: %s", s)
+	}
+}
+
+// rpcTransport encodes method calls for a given flavour of TracXMLRPCPlugin.
+// Decoded results are made of string, int, bool, float64, time.Time, []byte,
+// []interface{} and map[string]interface{} values, whatever the wire format.
+type rpcTransport interface {
+	call(method string, params ...interface{}) (interface{}, error)
+
+	// multicall sends several calls in a single system.multicall round-trip.
+	// The returned slice has one response per request, in the same order.
+	multicall(requests []rpcRequest) ([]rpcResponse, error)
+}
+
+type rpcRequest struct {
+	method string
+	params []interface{}
+}
+
+type rpcResponse struct {
+	value interface{}
+	err   error
+}
+
+var errRPCUnavailable = errors.New("RPC endpoint not available")
+var errRPCUnauthorized = errors.New("RPC request not authorized")
+
+// RPCFault is returned when the Trac RPC plugin reports an error for a call.
+type RPCFault struct {
+	Code    int
+	Message string
+}
+
+func (f *RPCFault) Error() string {
+	return fmt.Sprintf("RPC fault %d: %s", f.Code, f.Message)
+}
+
+func (c *Client) SetBackend(backend Backend) {
+	switch backend {
+	case BackendCSV:
+		c.rpc = nil
+	case BackendXMLRPC:
+		c.rpc = &xmlRPCTransport{client: c}
+	case BackendJSONRPC:
+		c.rpc = &jsonRPCTransport{client: c}
+	default:
+		panic("Unknown backend")
+	}
+}
+
+// rpcURL returns the URL of the RPC endpoint at path. Authenticated clients
+// must go through the /login prefix for Trac to honour their credentials.
+func (c *Client) rpcURL(path string) string {
+	if c.username != "" {
+		return c.url + "/login/" + path
+	}
+
+	return c.url + "/" + path
+}
+
+func (c *Client) rpcPost(path string, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest("POST", c.rpcURL(path), bytes.NewReader(body))
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while initializing request")
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	if c.authType == AuthBasic && c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.client.Do(req)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while sending RPC request")
+	}
+
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, errRPCUnauthorized
+	case http.StatusNotFound:
+		return nil, errRPCUnavailable
+	default:
+		return nil, errors.Errorf("Unexpected HTTP status: %d", resp.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while reading response data")
+	}
+
+	return data, nil
+}
+
+// rpcRetry runs f, re-authenticating and running it once more if the session
+// expired. ErrPermissionDenied is returned if the request is still refused.
+func (c *Client) rpcRetry(f func() error) error {
+	if c.rpc == nil {
+		return errRPCUnavailable
+	}
+
+	err := f()
+
+	if errors.Cause(err) == errRPCUnauthorized && c.username != "" {
+		if err := c.reauthenticate(); err != nil {
+			return err
+		}
+
+		err = f()
+	}
+
+	if errors.Cause(err) == errRPCUnauthorized {
+		return ErrPermissionDenied
+	}
+
+	return err
+}
+
+// rpcCall invokes method on the configured RPC transport.
+func (c *Client) rpcCall(method string, params ...interface{}) (interface{}, error) {
+	var res interface{}
+
+	err := c.rpcRetry(func() (err error) {
+		res, err = c.rpc.call(method, params...)
+		return
+	})
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error while calling %s", method)
+	}
+
+	return res, nil
+}
+
+// rpcMulticall sends requests in a single round-trip on the configured RPC
+// transport.
+func (c *Client) rpcMulticall(requests []rpcRequest) ([]rpcResponse, error) {
+	var res []rpcResponse
+
+	err := c.rpcRetry(func() (err error) {
+		res, err = c.rpc.multicall(requests)
+		return
+	})
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while calling system.multicall")
+	}
+
+	if len(res) != len(requests) {
+		return nil, errors.Errorf("Unexpected number of results in multicall response: %d", len(res))
+	}
+
+	return res, nil
+}
+
+func rpcTicketID(id string) (int, error) {
+	n, err := strconv.Atoi(id)
+
+	if err != nil {
+		return 0, errors.Errorf("Invalid ticket ID: %s", id)
+	}
+
+	return n, nil
+}
+
+// formatRPCValue converts a decoded RPC value to the string representation
+// used in Ticket maps.
+func formatRPCValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.UTC().Format("2006-01-02 15:04:05")
+	case []byte:
+		return string(v)
+	case []interface{}:
+		values := make([]string, len(v))
+
+		for i, x := range v {
+			values[i] = formatRPCValue(x)
+		}
+
+		return strings.Join(values, ", ")
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func rpcString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+func rpcBool(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case int:
+		return v != 0
+	case string:
+		return v == "1" || v == "true"
+	default:
+		return false
+	}
+}
+
+func rpcTime(v interface{}) time.Time {
+	t, _ := v.(time.Time)
+	return t
+}
+
+func (c *Client) ticketFromRPC(v interface{}) (Ticket, error) {
+	fields, ok := v.([]interface{})
+
+	if !ok || len(fields) != 4 {
+		return Ticket{}, errors.New("Unexpected ticket structure in RPC response")
+	}
+
+	attributes, ok := fields[3].(map[string]interface{})
+
+	if !ok {
+		return Ticket{}, errors.New("Unexpected ticket attributes in RPC response")
+	}
+
+	ticket := Ticket{}
+
+	for name, value := range attributes {
+		ticket[name] = formatRPCValue(value)
+	}
+
+	ticket["id"] = formatRPCValue(fields[0])
+	ticket["_url"] = c.url + "/ticket/" + ticket["id"]
+
+	return ticket, nil
+}
+
+func (c *Client) getTicketRPC(id string) (Ticket, error) {
+	n, err := rpcTicketID(id)
+
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	res, err := c.rpcCall("ticket.get", n)
+
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	return c.ticketFromRPC(res)
+}
+
+func (c *Client) getTicketsRPC(ids []string) ([]Ticket, []error, error) {
+	tickets := make([]Ticket, len(ids))
+	errs := make([]error, len(ids))
+	requests := make([]rpcRequest, 0, len(ids))
+	indices := make([]int, 0, len(ids))
+
+	for i, id := range ids {
+		n, err := rpcTicketID(id)
+
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		requests = append(requests, rpcRequest{"ticket.get", []interface{}{n}})
+		indices = append(indices, i)
+	}
+
+	if len(requests) == 0 {
+		return tickets, errs, nil
+	}
+
+	responses, err := c.rpcMulticall(requests)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for j, res := range responses {
+		i := indices[j]
+
+		if res.err != nil {
+			errs[i] = errors.Wrap(res.err, "Error while calling ticket.get")
+		} else {
+			tickets[i], errs[i] = c.ticketFromRPC(res.value)
+		}
+	}
+
+	return tickets, errs, nil
+}
+
+// GetTickets retrieves several tickets at once. With an RPC backend, all the
+// tickets are fetched in a single request. The returned slices have the same
+// length as ids, errs[i] being set if ids[i] could not be retrieved.
+func (c *Client) GetTickets(ids []string) ([]Ticket, []error) {
+	if c.rpc != nil {
+		tickets, errs, err := c.getTicketsRPC(ids)
+
+		if err == nil {
+			return tickets, errs
+		}
+
+		if errors.Cause(err) != errRPCUnavailable {
+			errs = make([]error, len(ids))
+
+			for i := range errs {
+				errs[i] = err
+			}
+
+			return make([]Ticket, len(ids)), errs
+		}
+
+		log.Printf("RPC endpoint not available on %s, falling back to CSV export", c.url)
+	}
+
+	tickets := make([]Ticket, len(ids))
+	errs := make([]error, len(ids))
+
+	for i, id := range ids {
+		tickets[i], errs[i] = c.getTicketCSV(id)
+	}
+
+	return tickets, errs
+}
+
+// TicketField describes a ticket field as configured on the Trac instance.
+type TicketField struct {
+	Name    string
+	Label   string
+	Type    string
+	Options []string
+	Custom  bool
+}
+
+// GetTicketFields lists the ticket fields of the Trac instance. It is only
+// available with an RPC backend.
+func (c *Client) GetTicketFields() ([]TicketField, error) {
+	res, err := c.rpcCall("ticket.getTicketFields")
+
+	if err != nil {
+		return nil, err
+	}
+
+	list, ok := res.([]interface{})
+
+	if !ok {
+		return nil, errors.New("Unexpected ticket fields structure in RPC response")
+	}
+
+	fields := make([]TicketField, 0, len(list))
+
+	for _, item := range list {
+		attributes, ok := item.(map[string]interface{})
+
+		if !ok {
+			return nil, errors.New("Unexpected ticket field structure in RPC response")
+		}
+
+		field := TicketField{
+			Name:   rpcString(attributes["name"]),
+			Label:  rpcString(attributes["label"]),
+			Type:   rpcString(attributes["type"]),
+			Custom: rpcBool(attributes["custom"]),
+		}
+
+		if options, ok := attributes["options"].([]interface{}); ok {
+			for _, option := range options {
+				field.Options = append(field.Options, formatRPCValue(option))
+			}
+		}
+
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+func (c *Client) getTicketChangelogRPC(id string) ([]TicketChange, error) {
+	n, err := rpcTicketID(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.rpcCall("ticket.changeLog", n)
+
+	if err != nil {
+		return nil, err
+	}
+
+	list, ok := res.([]interface{})
+
+	if !ok {
+		return nil, errors.New("Unexpected changelog structure in RPC response")
+	}
+
+	changes := make([]TicketChange, 0, len(list))
+
+	for _, item := range list {
+		entry, ok := item.([]interface{})
+
+		if !ok || len(entry) < 5 {
+			return nil, errors.New("Unexpected changelog entry in RPC response")
+		}
+
+		change := TicketChange{
+			Time:     rpcTime(entry[0]),
+			Author:   formatRPCValue(entry[1]),
+			Field:    formatRPCValue(entry[2]),
+			OldValue: formatRPCValue(entry[3]),
+			NewValue: formatRPCValue(entry[4]),
+		}
+
+		if len(entry) > 5 {
+			change.Permanent = rpcBool(entry[5])
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// SearchResult is a match returned by the Trac search engine
+type SearchResult struct {
+	URL     string
+	Title   string
+	Date    time.Time
+	Author  string
+	Excerpt string
+}
+
+// absoluteURL resolves a link returned by Trac against the instance URL
+func (c *Client) absoluteURL(href string) string {
+	base, err := url.Parse(c.url)
+
+	if err != nil {
+		return href
+	}
+
+	ref, err := url.Parse(href)
+
+	if err != nil {
+		return href
+	}
+
+	return base.ResolveReference(ref).String()
+}
+
+// Search runs a query through the Trac search engine, optionally restricted
+// to some filters (eg. "ticket", "wiki", "changeset"). It is only available
+// with an RPC backend.
+func (c *Client) Search(query string, filters []string) ([]SearchResult, error) {
+	if c.rpc == nil {
+		return nil, errors.New("Searching requires an RPC backend")
+	}
+
+	params := []interface{}{query}
+
+	if len(filters) > 0 {
+		params = append(params, filters)
+	}
+
+	res, err := c.rpcCall("search.performSearch", params...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	list, ok := res.([]interface{})
+
+	if !ok {
+		return nil, errors.New("Unexpected search results structure in RPC response")
+	}
+
+	results := make([]SearchResult, 0, len(list))
+
+	for _, item := range list {
+		entry, ok := item.([]interface{})
+
+		if !ok || len(entry) != 5 {
+			return nil, errors.New("Unexpected search result in RPC response")
+		}
+
+		results = append(results, SearchResult{
+			URL:     c.absoluteURL(formatRPCValue(entry[0])),
+			Title:   formatRPCValue(entry[1]),
+			Date:    rpcTime(entry[2]),
+			Author:  formatRPCValue(entry[3]),
+			Excerpt: formatRPCValue(entry[4]),
+		})
+	}
+
+	return results, nil
+}
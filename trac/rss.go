@@ -0,0 +1,67 @@
+package trac
+
+import (
+	"encoding/xml"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// rssItem is an entry of the RSS feeds exported by Trac
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        string   `xml:"guid"`
+	Description string   `xml:"description"`
+	Creator     string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Author      string   `xml:"author"`
+	PubDate     string   `xml:"pubDate"`
+	Categories  []string `xml:"category"`
+}
+
+type rssFeed struct {
+	Items []rssItem `xml:"channel>item"`
+}
+
+// author returns the author of the item, Trac using either dc:creator or
+// author depending on whether it knows the email address of the user.
+func (i rssItem) author() string {
+	if len(i.Creator) > 0 {
+		return i.Creator
+	}
+
+	// author is formatted as "email (name)"
+	if idx := strings.Index(i.Author, " ("); idx >= 0 && strings.HasSuffix(i.Author, ")") {
+		return i.Author[idx+2 : len(i.Author)-1]
+	}
+
+	return i.Author
+}
+
+func (i rssItem) date() time.Time {
+	for _, layout := range []string{time.RFC1123, time.RFC1123Z} {
+		if t, err := time.Parse(layout, i.PubDate); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// getRSS retrieves and decodes an RSS feed of the Trac web interface
+func (c *Client) getRSS(path string) ([]rssItem, error) {
+	data, err := c.get(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var feed rssFeed
+
+	if err := xml.Unmarshal(data, &feed); err != nil {
+		return nil, errors.Wrap(err, "Error while decoding RSS")
+	}
+
+	return feed.Items, nil
+}
@@ -0,0 +1,127 @@
+package trac
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Kinds of timeline events, as reported in the categories of the timeline RSS
+// feed
+const (
+	TimelineNewTicket      = "newticket"
+	TimelineClosedTicket   = "closedticket"
+	TimelineReopenedTicket = "reopenedticket"
+	TimelineEditedTicket   = "editedticket"
+	TimelineWiki           = "wiki"
+	TimelineChangeset      = "changeset"
+	TimelineMilestone      = "milestone"
+)
+
+// timelineSources maps event kinds to the timeline filter producing them
+var timelineSources = map[string]string{
+	TimelineNewTicket:      "ticket",
+	TimelineClosedTicket:   "ticket",
+	TimelineReopenedTicket: "ticket",
+	TimelineEditedTicket:   "ticket_details",
+	TimelineWiki:           "wiki",
+	TimelineChangeset:      "changeset",
+	TimelineMilestone:      "milestone",
+}
+
+// TimelineEvent is an entry of the Trac timeline
+type TimelineEvent struct {
+	// One of the Timeline* constants, or another category for events coming
+	// from plugins
+	Kind string
+
+	Title       string
+	Author      string
+	Date        time.Time
+	Description string
+
+	// ID of the ticket for ticket events, empty otherwise
+	TicketID string
+
+	// URL of the event in the Trac web interface
+	URL string
+}
+
+// GetTimeline returns the timeline events which occurred from since until
+// until, both included, oldest first. Timeline dates are only precise to the
+// second, so callers have to skip the events of the since second they already
+// know about. kinds lists the kinds of events to retrieve (see the
+// Timeline* constants), all known kinds are retrieved if it is empty. Events
+// of other kinds coming from the same Trac timeline filter can be returned too.
+func (c *Client) GetTimeline(since, until time.Time, kinds []string) ([]TimelineEvent, error) {
+	if len(kinds) == 0 {
+		for kind := range timelineSources {
+			kinds = append(kinds, kind)
+		}
+	}
+
+	// Trac looks back a number of days from the "from" date
+	daysBack := int(until.Sub(since).Hours()/24) + 1
+
+	params := url.Values{
+		"format":   {"rss"},
+		"from":     {until.UTC().Format("2006-01-02")},
+		"daysback": {strconv.Itoa(daysBack)},
+		"max":      {"0"},
+	}
+
+	for _, kind := range kinds {
+		source, ok := timelineSources[kind]
+
+		if !ok {
+			return nil, errors.Errorf("Unknown timeline event kind: %s", kind)
+		}
+
+		params.Set(source, "on")
+
+		// Trac only lists ticket changes other than status changes when
+		// asked for the details
+		if source == "ticket_details" {
+			params.Set("ticket", "on")
+		}
+	}
+
+	items, err := c.getRSS("/timeline?" + params.Encode())
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while retrieving timeline")
+	}
+
+	var events []TimelineEvent
+
+	for _, item := range items {
+		event := TimelineEvent{
+			Title:       item.Title,
+			Author:      item.author(),
+			Date:        item.date(),
+			Description: htmlToText(item.Description),
+			URL:         item.Link,
+		}
+
+		if event.Date.Before(since) || event.Date.After(until) {
+			continue
+		}
+
+		if len(item.Categories) > 0 {
+			event.Kind = item.Categories[0]
+		}
+
+		if match := TICKET_LOCATION_RE.FindStringSubmatch(item.Link); match != nil {
+			event.TicketID = match[1]
+		}
+
+		events = append(events, event)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Date.Before(events[j].Date) })
+
+	return events, nil
+}
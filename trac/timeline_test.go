@@ -0,0 +1,104 @@
+package trac
+
+import (
+	"testing"
+	"time"
+)
+
+const testTimelineRSS = `<?xml version="1.0"?>
+<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/">
+<channel>
+<title>Timeline</title>
+<item>
+<title>Changeset [1200]: Fix panic in backend</title>
+<dc:creator>bob</dc:creator>
+<pubDate>Wed, 04 Jan 2017 09:00:00 GMT</pubDate>
+<link>` + testUrl + `/changeset/1200</link>
+<description>Fix panic in backend</description>
+<category>changeset</category>
+</item>
+<item>
+<title>Ticket #35 (Panic in backend) closed</title>
+<dc:creator>bob</dc:creator>
+<pubDate>Wed, 04 Jan 2017 09:05:00 GMT</pubDate>
+<link>` + testUrl + `/ticket/35#comment:3</link>
+<description>fixed: In [1200]</description>
+<category>closedticket</category>
+</item>
+<item>
+<title>Ticket #35 (Panic in backend) created</title>
+<author>alice@example.com (alice)</author>
+<pubDate>Tue, 03 Jan 2017 11:30:00 GMT</pubDate>
+<link>` + testUrl + `/ticket/35</link>
+<description>&lt;p&gt;The backend panics&lt;/p&gt;</description>
+<category>newticket</category>
+</item>
+<item>
+<title>Dev/Guidelines edited</title>
+<dc:creator>alice</dc:creator>
+<pubDate>Mon, 02 Jan 2017 10:00:00 GMT</pubDate>
+<link>` + testUrl + `/wiki/Dev/Guidelines?version=3</link>
+<category>wiki</category>
+</item>
+</channel>
+</rss>`
+
+func TestGetTimeline(t *testing.T) {
+	s := testServer(t)
+	s.sendPage("/timeline?changeset=on&daysback=2&format=rss&from=2017-01-04&max=0&ticket=on&ticket_details=on", testTimelineRSS)
+
+	client, err := NewWithHttpClient(testUrl, AuthBasic, false, s)
+
+	if err != nil {
+		t.Fatalf("Error while creating client: %s", err)
+	}
+
+	since := time.Date(2017, 1, 3, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2017, 1, 4, 12, 0, 0, 0, time.UTC)
+
+	events, err := client.GetTimeline(since, until, []string{TimelineNewTicket, TimelineEditedTicket, TimelineChangeset})
+
+	if err != nil {
+		t.Fatalf("GetTimeline failed: %s", err)
+	}
+
+	expected := []TimelineEvent{
+		{TimelineNewTicket, "Ticket #35 (Panic in backend) created", "alice", time.Date(2017, 1, 3, 11, 30, 0, 0, time.UTC), "The backend panics", "35", testUrl + "/ticket/35"},
+		{TimelineChangeset, "Changeset [1200]: Fix panic in backend", "bob", time.Date(2017, 1, 4, 9, 0, 0, 0, time.UTC), "Fix panic in backend", "", testUrl + "/changeset/1200"},
+		{TimelineClosedTicket, "Ticket #35 (Panic in backend) closed", "bob", time.Date(2017, 1, 4, 9, 5, 0, 0, time.UTC), "fixed: In [1200]", "35", testUrl + "/ticket/35#comment:3"},
+	}
+
+	if len(events) != len(expected) {
+		t.Fatalf("Unexpected number of events: %d", len(events))
+	}
+
+	for i, event := range events {
+		if !event.Date.Equal(expected[i].Date) {
+			t.Errorf("Unexpected date for event %d: %s", i, event.Date)
+		}
+
+		event.Date = expected[i].Date
+
+		if event != expected[i] {
+			t.Errorf("Unexpected event %d: %+v", i, event)
+		}
+	}
+
+	// Events of the since second are returned, dates being precise to the
+	// second
+	s.sendPage("/timeline?changeset=on&daysback=1&format=rss&from=2017-01-04&max=0", testTimelineRSS)
+
+	events, err = client.GetTimeline(time.Date(2017, 1, 4, 9, 0, 0, 0, time.UTC), until, []string{TimelineChangeset})
+
+	if err != nil {
+		t.Fatalf("GetTimeline failed: %s", err)
+	}
+
+	if len(events) != 2 || events[0].URL != testUrl+"/changeset/1200" {
+		t.Errorf("Unexpected events since the changeset: %+v", events)
+	}
+
+	if _, err := client.GetTimeline(since, until, []string{"unknown"}); err == nil {
+		t.Errorf("GetTimeline should fail for unknown event kinds")
+	}
+}
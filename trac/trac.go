@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/tls"
 	"encoding/csv"
+	"html"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -30,6 +31,7 @@ type Client struct {
 	client   HttpClient
 	username string
 	password string
+	rpc      rpcTransport
 }
 
 // Ticket in Trac can come in any shape, so our representation is just a map of
@@ -134,36 +136,103 @@ func (c *Client) authenticateBasic(username, password string) error {
 	return errors.Errorf("Unexpected HTTP status: %d", resp.StatusCode)
 }
 
-var TOKEN_FORM_RE = regexp.MustCompile(`<input\s+type="hidden"\s+name="__FORM_TOKEN"\s+value="([a-z0-9]+)"\s+/>`)
+var HIDDEN_INPUT_RE = regexp.MustCompile(`<input\s+type="hidden"\s+name="([^"]+)"\s+value="([^"]*)"\s*/>`)
 
-func (c *Client) getLoginFormToken() (string, error) {
-	resp, err := httpGet(c.client, c.url+"/login")
+// getFormInputs returns the hidden inputs of the forms of the page at path,
+// such as the token protecting them against CSRF
+func (c *Client) getFormInputs(path string) (map[string]string, error) {
+	resp, err := httpGet(c.client, c.url+path)
 
 	if err != nil {
-		return "", errors.Wrap(err, "Error while retrieving login page")
+		return nil, errors.Wrapf(err, "Error while retrieving %s page", path)
 	}
 
 	defer resp.Body.Close()
 
-	loginHtml, err := ioutil.ReadAll(resp.Body)
+	pageHtml, err := ioutil.ReadAll(resp.Body)
 
 	if err != nil {
-		return "", errors.Wrap(err, "Error while reading login page")
+		return nil, errors.Wrapf(err, "Error while reading %s page", path)
 	}
 
-	// It seems we could also retrieve the value from the cookies
-	match := TOKEN_FORM_RE.FindSubmatch(loginHtml)
+	inputs := map[string]string{}
 
-	if match == nil {
-		return "", errors.New("Cannot find form token in login page")
+	for _, match := range HIDDEN_INPUT_RE.FindAllSubmatch(pageHtml, -1) {
+		inputs[string(match[1])] = html.UnescapeString(string(match[2]))
 	}
 
-	return string(match[1]), nil
+	// It seems we could also retrieve the token from the cookies
+	if len(inputs["__FORM_TOKEN"]) == 0 {
+		return nil, errors.Errorf("Cannot find form token in %s page", path)
+	}
+
+	return inputs, nil
+}
+
+var SYSTEM_MESSAGE_RE = regexp.MustCompile(`(?s)<div[^>]*class="[^"]*system-message[^"]*"[^>]*>(.*?)</div>`)
+
+// formError returns the error displayed by Trac when it rejects a form
+func formError(page []byte) error {
+	if match := SYSTEM_MESSAGE_RE.FindSubmatch(page); match != nil {
+		return errors.New(strings.Join(strings.Fields(htmlToText(string(match[1]))), " "))
+	}
+
+	return errors.New("The form was rejected")
+}
+
+// postForm submits a form of the Trac web interface, path being the page
+// holding the form. The hidden inputs of the form are added to values. It
+// returns the redirection target if Trac accepted the form.
+func (c *Client) postForm(path string, values url.Values) (string, error) {
+	inputs, err := c.getFormInputs(path)
+
+	if err != nil {
+		return "", errors.Wrap(err, "Error while loading form token")
+	}
+
+	// Hidden inputs carry the CSRF token, and the view time of the page
+	// which Trac uses to detect concurrent edits
+	for name, value := range inputs {
+		if _, ok := values[name]; !ok {
+			values.Set(name, value)
+		}
+	}
+
+	resp, err := httpPostForm(c.client, c.url+path, values)
+
+	if err != nil {
+		return "", errors.Wrap(err, "Error while submitting form")
+	}
+
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusFound, http.StatusSeeOther:
+		return resp.Header.Get("Location"), nil
+	case http.StatusOK:
+		page, err := ioutil.ReadAll(resp.Body)
+
+		if err != nil {
+			return "", errors.Wrap(err, "Error while reading response data")
+		}
+
+		// The HTTP client follows redirections when it has no session cookie
+		if resp.Request != nil && !strings.HasSuffix(resp.Request.URL.Path, path) {
+			return resp.Request.URL.String(), nil
+		}
+
+		// Trac displays the form again when it is rejected
+		return "", formError(page)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "", errors.New("Permission denied")
+	default:
+		return "", errors.Errorf("Unexpected HTTP status: %d", resp.StatusCode)
+	}
 }
 
 func (c *Client) authenticateForm(username, password string) error {
 	// First get the login page to get the form token
-	formToken, err := c.getLoginFormToken()
+	inputs, err := c.getFormInputs("/login")
 
 	if err != nil {
 		return errors.Wrap(err, "Error while loading form token")
@@ -173,7 +242,7 @@ func (c *Client) authenticateForm(username, password string) error {
 		"user":         {username},
 		"password":     {password},
 		"referer":      {c.url},
-		"__FORM_TOKEN": {formToken},
+		"__FORM_TOKEN": {inputs["__FORM_TOKEN"]},
 	})
 
 	if err != nil {
@@ -225,35 +294,84 @@ func (c *Client) reauthenticate() error {
 }
 
 func (c *Client) GetTicket(id string) (Ticket, error) {
-	ticketUrl := c.url + "/ticket/" + id
-	csvTicketUrl := ticketUrl + "?format=csv"
+	if c.rpc != nil {
+		ticket, err := c.getTicketRPC(id)
 
-	log.Printf("GET %s", csvTicketUrl)
+		if errors.Cause(err) != errRPCUnavailable {
+			return ticket, err
+		}
 
-	resp, err := httpGet(c.client, csvTicketUrl)
+		log.Printf("RPC endpoint not available on %s, falling back to CSV export", c.url)
+	}
+
+	return c.getTicketCSV(id)
+}
+
+var errNotFound = errors.New("Not found")
+
+// ErrPermissionDenied is returned when Trac refuses access to a page, even
+// after re-authenticating
+var ErrPermissionDenied = errors.New("Permission denied")
+
+// get retrieves a page of the Trac web interface, re-authenticating once if
+// access is refused. path is relative to the instance URL.
+func (c *Client) get(path string) ([]byte, error) {
+	data, err := c.getOnce(path)
+
+	// Refused pages stay refused for an authenticated user, retrying once is
+	// enough to renew an expired session
+	if err == ErrPermissionDenied && c.username != "" {
+		if err := c.reauthenticate(); err != nil {
+			return nil, errors.Wrap(err, "Error while re-authenticating")
+		}
+
+		data, err = c.getOnce(path)
+	}
+
+	return data, err
+}
+
+// getOnce retrieves a page of the Trac web interface
+func (c *Client) getOnce(path string) ([]byte, error) {
+	pageUrl := c.url + path
+
+	log.Printf("GET %s", pageUrl)
+
+	resp, err := httpGet(c.client, pageUrl)
 
 	if err != nil {
-		return Ticket{}, errors.Wrap(err, "Error while sending ticket request")
+		return nil, errors.Wrap(err, "Error while sending request")
 	}
 
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
-		if err := c.reauthenticate(); err != nil {
-			return Ticket{}, errors.Wrap(err, "Error while re-authenticating")
-		}
+		return nil, ErrPermissionDenied
+	}
 
-		return c.GetTicket(id)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return Ticket{}, errors.Errorf("Unexpected HTTP status: %d", resp.StatusCode)
+		return nil, errors.Errorf("Unexpected HTTP status: %d", resp.StatusCode)
 	}
 
-	csvData, err := ioutil.ReadAll(resp.Body)
+	data, err := ioutil.ReadAll(resp.Body)
 
 	if err != nil {
-		return Ticket{}, errors.Wrap(err, "Error while reading response data")
+		return nil, errors.Wrap(err, "Error while reading response data")
+	}
+
+	return data, nil
+}
+
+// getCSV retrieves and decodes a CSV export of the Trac web interface
+func (c *Client) getCSV(path string) ([][]string, error) {
+	csvData, err := c.get(path)
+
+	if err != nil {
+		return nil, err
 	}
 
 	// Trac seems to send a UTF8 BOM, strip it if present
@@ -264,7 +382,17 @@ func (c *Client) GetTicket(id string) (Ticket, error) {
 	records, err := csv.NewReader(bytes.NewReader(csvData)).ReadAll()
 
 	if err != nil {
-		return Ticket{}, errors.Wrap(err, "Error while decoding CSV")
+		return nil, errors.Wrap(err, "Error while decoding CSV")
+	}
+
+	return records, nil
+}
+
+func (c *Client) getTicketCSV(id string) (Ticket, error) {
+	records, err := c.getCSV("/ticket/" + id + "?format=csv")
+
+	if err != nil {
+		return Ticket{}, errors.Wrap(err, "Error while retrieving ticket")
 	}
 
 	if len(records) != 2 || len(records[0]) != len(records[1]) {
@@ -277,7 +405,7 @@ func (c *Client) GetTicket(id string) (Ticket, error) {
 		ticket[field] = records[1][idx]
 	}
 
-	ticket["_url"] = ticketUrl
+	ticket["_url"] = c.url + "/ticket/" + id
 
 	return ticket, nil
 }
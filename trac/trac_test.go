@@ -6,6 +6,8 @@ import (
 	"io/ioutil"
 	"net/http"
 	"testing"
+
+	"github.com/pkg/errors"
 )
 
 type TestServer struct {
@@ -155,3 +157,37 @@ func TestReauthenticate(t *testing.T) {
 		t.Errorf("GetTicket failed")
 	}
 }
+
+func (s *TestServer) refuseTicket() {
+	s.steps = append(s.steps, func(req *http.Request) *http.Response {
+		return makeResponse(http.StatusForbidden, req)
+	})
+}
+
+func TestPermissionDenied(t *testing.T) {
+	s := testServer(t)
+	s.authenticate()
+	s.refuseTicket()
+	s.authenticate()
+	s.refuseTicket()
+
+	client, err := NewWithHttpClient(testUrl, AuthBasic, false, s)
+
+	if err != nil {
+		t.Fatalf("Error while creating client: %s", err)
+	}
+
+	if err := client.Authenticate(testUsername, testPassword); err != nil {
+		t.Fatalf("Authenticate failed: %s", err)
+	}
+
+	// The page is refused again after re-authenticating, which should not be
+	// retried forever
+	if _, err := client.GetTicket("33"); errors.Cause(err) != ErrPermissionDenied {
+		t.Errorf("Expected a permission error, got %v", err)
+	}
+
+	if s.currentStep != len(s.steps) {
+		t.Errorf("Expected %d requests, got %d", len(s.steps), s.currentStep)
+	}
+}
@@ -0,0 +1,223 @@
+package trac
+
+import (
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrTicketConflict is returned by UpdateTicket when the ticket was modified
+// by someone else between the moment it was read and the moment it was saved.
+var ErrTicketConflict = errors.New("Ticket was modified by someone else in the meantime")
+
+var TICKET_COLLISION_RE = regexp.MustCompile(`modified by someone else`)
+
+// UpdateTicket comments on a ticket and/or changes its fields. Both comment and
+// attributes can be empty. Trac rejects the change if the ticket was modified
+// concurrently, in which case ErrTicketConflict is returned. Only the changes
+// made while UpdateTicket runs are detected: the ticket version is read just
+// before saving, not when the user last saw the ticket.
+//
+// Status changes go through the ticket workflow: the status, resolution and
+// owner attributes are translated into the matching workflow action, which can
+// also be passed explicitly as attributes named after the form inputs (eg.
+// action=resolve, action_resolve_resolve_resolution=fixed). The updated
+// ticket is checked, since Trac silently ignores the changes that the workflow
+// does not allow.
+func (c *Client) UpdateTicket(id, comment string, attributes map[string]string) error {
+	if len(strings.TrimSpace(comment)) == 0 && len(attributes) == 0 {
+		return errors.New("Nothing to change")
+	}
+
+	attributes, expected, err := workflowAttributes(attributes)
+
+	if err != nil {
+		return err
+	}
+
+	if c.rpc != nil {
+		ticket, err := c.updateTicketRPC(id, comment, attributes)
+
+		if errors.Cause(err) != errRPCUnavailable {
+			if err != nil {
+				return err
+			}
+
+			return checkTicketUpdate(ticket, expected)
+		}
+
+		log.Printf("RPC endpoint not available on %s, falling back to web interface", c.url)
+	}
+
+	if err := c.updateTicketWeb(id, comment, attributes); err != nil {
+		return err
+	}
+
+	if len(expected) == 0 {
+		return nil
+	}
+
+	ticket, err := c.GetTicket(id)
+
+	if err != nil {
+		return errors.Wrapf(err, "Ticket %s was updated, but could not be checked", id)
+	}
+
+	return checkTicketUpdate(ticket, expected)
+}
+
+// workflowAttributes replaces the status, resolution and owner attributes,
+// which Trac ignores, by the workflow action setting them. It also returns the
+// field values expected once the ticket is updated.
+func workflowAttributes(attributes map[string]string) (map[string]string, map[string]string, error) {
+	result := map[string]string{}
+	expected := map[string]string{}
+
+	for name, value := range attributes {
+		switch {
+		case name == "status" || name == "resolution" || name == "owner":
+		case strings.HasPrefix(name, "action"):
+			result[name] = value
+		default:
+			result[name] = value
+			expected[name] = value
+		}
+	}
+
+	status, hasStatus := attributes["status"]
+	resolution, hasResolution := attributes["resolution"]
+	owner, hasOwner := attributes["owner"]
+
+	if hasStatus || hasResolution || hasOwner {
+		if _, ok := attributes["action"]; ok {
+			return nil, nil, errors.New("Set either the workflow action or the status, resolution and owner, not both")
+		}
+
+		if (hasStatus || hasResolution) && hasOwner {
+			return nil, nil, errors.New("The status and the owner cannot be changed at once")
+		}
+	}
+
+	switch {
+	case hasOwner:
+		result["action"] = "reassign"
+		result["action_reassign_reassign_owner"] = owner
+	case hasResolution && (!hasStatus || status == "closed"):
+		result["action"] = "resolve"
+		result["action_resolve_resolve_resolution"] = resolution
+	case hasResolution:
+		return nil, nil, errors.Errorf("A resolution can only be set when closing the ticket, not with status %s", status)
+	case !hasStatus:
+	case status == "closed":
+		result["action"] = "resolve"
+		result["action_resolve_resolve_resolution"] = "fixed"
+	case status == "reopened":
+		result["action"] = "reopen"
+	case status == "accepted":
+		result["action"] = "accept"
+	default:
+		return nil, nil, errors.Errorf("Status %s cannot be set, use closed (with an optional resolution), reopened or accepted", status)
+	}
+
+	// The outcome of the default workflow actions
+	switch result["action"] {
+	case "resolve":
+		expected["status"] = "closed"
+		expected["resolution"] = result["action_resolve_resolve_resolution"]
+	case "reassign":
+		expected["owner"] = result["action_reassign_reassign_owner"]
+	case "reopen":
+		expected["status"] = "reopened"
+	case "accept":
+		expected["status"] = "accepted"
+	}
+
+	return result, expected, nil
+}
+
+// normalizeFieldValue ignores the spacing of a field value, which Trac may
+// change, eg. in CC lists
+func normalizeFieldValue(value string) string {
+	return strings.Join(strings.Fields(strings.Replace(value, ",", " ", -1)), " ")
+}
+
+// checkTicketUpdate checks that an updated ticket has the expected field values
+func checkTicketUpdate(ticket Ticket, expected map[string]string) error {
+	for name, value := range expected {
+		if actual := ticket[name]; normalizeFieldValue(actual) != normalizeFieldValue(value) {
+			return errors.Errorf("Trac did not set %s to %q (it is %q), the ticket workflow or your permissions may not allow it", name, value, actual)
+		}
+	}
+
+	return nil
+}
+
+// updateTicketRPC updates a ticket through the RPC API, and returns the updated
+// ticket
+func (c *Client) updateTicketRPC(id, comment string, attributes map[string]string) (Ticket, error) {
+	// The _ts field of the ticket is its version, Trac compares it to the
+	// current one before saving
+	ticket, err := c.getTicketRPC(id)
+
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	n, _ := rpcTicketID(id)
+	changes := map[string]string{"_ts": ticket["_ts"]}
+
+	for name, value := range attributes {
+		changes[name] = value
+	}
+
+	res, err := c.rpcCall("ticket.update", n, comment, changes, true)
+
+	if fault, ok := errors.Cause(err).(*RPCFault); ok && TICKET_COLLISION_RE.MatchString(fault.Message) {
+		return Ticket{}, ErrTicketConflict
+	}
+
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	return c.ticketFromRPC(res)
+}
+
+func (c *Client) updateTicketWeb(id, comment string, attributes map[string]string) error {
+	if _, err := rpcTicketID(id); err != nil {
+		return err
+	}
+
+	values := url.Values{
+		"comment": {comment},
+		"action":  {"leave"},
+		"submit":  {"Submit changes"},
+	}
+
+	for name, value := range attributes {
+		if strings.HasPrefix(name, "action") {
+			values.Set(name, value)
+		} else {
+			values.Set("field_"+name, value)
+		}
+	}
+
+	location, err := c.postForm("/ticket/"+id, values)
+
+	if err != nil {
+		if TICKET_COLLISION_RE.MatchString(err.Error()) {
+			return ErrTicketConflict
+		}
+
+		return errors.Wrapf(err, "Error while updating ticket %s", id)
+	}
+
+	if !TICKET_LOCATION_RE.MatchString(location) {
+		return errors.Errorf("Unexpected redirection after ticket update: %s", location)
+	}
+
+	return nil
+}
@@ -0,0 +1,210 @@
+package trac
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testTicketPage = `<form id="propertyform" action="/testPrefix/ticket/33" method="post">
+<div><input type="hidden" name="__FORM_TOKEN" value="3f2b8c9d0e1a" /></div>
+<input type="hidden" name="start_time" value="1483443000000000" />
+<input type="hidden" name="view_time" value="1483443000000000" />
+</form>`
+
+// xmlRPCClosedTicketResponse is the ticket of xmlRPCTicketResponse once
+// closed as fixed
+var xmlRPCClosedTicketResponse = strings.Replace(xmlRPCTicketResponse,
+	"<member><name>status</name><value>new</value></member>",
+	"<member><name>status</name><value>closed</value></member><member><name>resolution</name><value>fixed</value></member>", 1)
+
+func TestUpdateTicketXMLRPC(t *testing.T) {
+	s := testServer(t)
+	s.authenticate()
+	s.xmlRPC("ticket.get", []interface{}{33}, xmlRPCTicketResponse)
+	s.xmlRPC("ticket.update", []interface{}{
+		33,
+		"Fixed in r1200",
+		map[string]string{"_ts": "1483443000000000", "action": "resolve", "action_resolve_resolve_resolution": "fixed"},
+		true,
+	}, xmlRPCClosedTicketResponse)
+
+	client := newXMLRPCTestClient(t, s)
+
+	if err := client.UpdateTicket("33", "Fixed in r1200", map[string]string{"status": "closed", "resolution": "fixed"}); err != nil {
+		t.Fatalf("UpdateTicket failed: %s", err)
+	}
+}
+
+func TestUpdateTicketXMLRPCConflict(t *testing.T) {
+	s := testServer(t)
+	s.authenticate()
+	s.xmlRPC("ticket.get", []interface{}{33}, xmlRPCTicketResponse)
+	s.xmlRPC("ticket.update", []interface{}{
+		33,
+		"Fixed in r1200",
+		map[string]string{"_ts": "1483443000000000"},
+		true,
+	}, `<?xml version="1.0"?><methodResponse><fault><value><struct>
+<member><name>faultCode</name><value><int>2</int></value></member>
+<member><name>faultString</name><value><string>Sorry, can not save your changes. This ticket has been modified by someone else since you started</string></value></member>
+</struct></value></fault></methodResponse>`)
+
+	client := newXMLRPCTestClient(t, s)
+
+	if err := client.UpdateTicket("33", "Fixed in r1200", nil); err != ErrTicketConflict {
+		t.Errorf("Unexpected error: %v, expected ErrTicketConflict", err)
+	}
+}
+
+func TestUpdateTicketWeb(t *testing.T) {
+	s := testServer(t)
+	s.sendPage("/ticket/33", testTicketPage)
+	s.submitForm("/ticket/33", map[string]string{
+		"__FORM_TOKEN":                      "3f2b8c9d0e1a",
+		"view_time":                         "1483443000000000",
+		"comment":                           "Fixed in r1200",
+		"action":                            "resolve",
+		"action_resolve_resolve_resolution": "fixed",
+		"field_milestone":                   "1.4",
+	}, func(req *http.Request) *http.Response {
+		res := makeResponse(http.StatusSeeOther, req)
+		res.Header = http.Header{"Location": {testUrl + "/ticket/33#comment:4"}}
+
+		return res
+	})
+	s.sendPage("/ticket/33?format=csv", "id,status,resolution,milestone\n33,closed,fixed,1.4\n")
+
+	client, err := NewWithHttpClient(testUrl, AuthBasic, false, s)
+
+	if err != nil {
+		t.Fatalf("Error while creating client: %s", err)
+	}
+
+	err = client.UpdateTicket("33", "Fixed in r1200", map[string]string{
+		"action":                            "resolve",
+		"action_resolve_resolve_resolution": "fixed",
+		"milestone":                         "1.4",
+	})
+
+	if err != nil {
+		t.Fatalf("UpdateTicket failed: %s", err)
+	}
+}
+
+func TestUpdateTicketWebConflict(t *testing.T) {
+	s := testServer(t)
+	s.sendPage("/ticket/33", testTicketPage)
+	s.submitForm("/ticket/33", nil, func(req *http.Request) *http.Response {
+		res := makeResponse(http.StatusOK, req)
+		res.Body = ioutil.NopCloser(bytes.NewReader([]byte(`<div id="warning" class="system-message warning">
+Sorry, can not save your changes. This ticket has been modified by someone else since you started
+</div>` + testTicketPage)))
+
+		return res
+	})
+
+	client, err := NewWithHttpClient(testUrl, AuthBasic, false, s)
+
+	if err != nil {
+		t.Fatalf("Error while creating client: %s", err)
+	}
+
+	if err := client.UpdateTicket("33", "Fixed in r1200", nil); err != ErrTicketConflict {
+		t.Errorf("Unexpected error: %v, expected ErrTicketConflict", err)
+	}
+}
+
+func TestUpdateTicketWebIgnored(t *testing.T) {
+	s := testServer(t)
+	s.sendPage("/ticket/33", testTicketPage)
+	s.submitForm("/ticket/33", map[string]string{
+		"__FORM_TOKEN":                      "3f2b8c9d0e1a",
+		"view_time":                         "1483443000000000",
+		"action":                            "resolve",
+		"action_resolve_resolve_resolution": "wontfix",
+	}, func(req *http.Request) *http.Response {
+		res := makeResponse(http.StatusSeeOther, req)
+		res.Header = http.Header{"Location": {testUrl + "/ticket/33#comment:4"}}
+
+		return res
+	})
+	// The workflow did not let the user close the ticket
+	s.sendPage("/ticket/33?format=csv", "id,status,resolution\n33,new,\n")
+
+	client, err := NewWithHttpClient(testUrl, AuthBasic, false, s)
+
+	if err != nil {
+		t.Fatalf("Error while creating client: %s", err)
+	}
+
+	err = client.UpdateTicket("33", "", map[string]string{"status": "closed", "resolution": "wontfix"})
+
+	if err == nil || !strings.Contains(err.Error(), "did not set") {
+		t.Errorf("Unexpected error for an ignored change: %v", err)
+	}
+}
+
+func TestWorkflowAttributes(t *testing.T) {
+	testData := []struct {
+		attributes map[string]string
+		result     map[string]string
+		expected   map[string]string
+		err        bool
+	}{
+		{
+			map[string]string{"status": "closed", "milestone": "1.4"},
+			map[string]string{"action": "resolve", "action_resolve_resolve_resolution": "fixed", "milestone": "1.4"},
+			map[string]string{"status": "closed", "resolution": "fixed", "milestone": "1.4"},
+			false,
+		},
+		{
+			map[string]string{"status": "closed", "resolution": "duplicate"},
+			map[string]string{"action": "resolve", "action_resolve_resolve_resolution": "duplicate"},
+			map[string]string{"status": "closed", "resolution": "duplicate"},
+			false,
+		},
+		{
+			map[string]string{"owner": "alice"},
+			map[string]string{"action": "reassign", "action_reassign_reassign_owner": "alice"},
+			map[string]string{"owner": "alice"},
+			false,
+		},
+		{
+			map[string]string{"status": "reopened"},
+			map[string]string{"action": "reopen"},
+			map[string]string{"status": "reopened"},
+			false,
+		},
+		{
+			map[string]string{"action": "reassign", "action_reassign_reassign_owner": "bob"},
+			map[string]string{"action": "reassign", "action_reassign_reassign_owner": "bob"},
+			map[string]string{"owner": "bob"},
+			false,
+		},
+		{map[string]string{"status": "assigned"}, nil, nil, true},
+		{map[string]string{"status": "reopened", "resolution": "fixed"}, nil, nil, true},
+		{map[string]string{"status": "closed", "owner": "alice"}, nil, nil, true},
+		{map[string]string{"action": "leave", "status": "closed"}, nil, nil, true},
+	}
+
+	for _, test := range testData {
+		result, expected, err := workflowAttributes(test.attributes)
+
+		if (err != nil) != test.err {
+			t.Errorf("Unexpected error for %v: %v", test.attributes, err)
+			continue
+		}
+
+		if test.err {
+			continue
+		}
+
+		if !reflect.DeepEqual(result, test.result) || !reflect.DeepEqual(expected, test.expected) {
+			t.Errorf("Unexpected workflow attributes for %v: %v (expecting %v)", test.attributes, result, expected)
+		}
+	}
+}
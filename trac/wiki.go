@@ -0,0 +1,169 @@
+package trac
+
+import (
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// WikiPage holds the latest version of a Trac wiki page
+type WikiPage struct {
+	// Name of the page, eg. WikiStart or Dev/Guidelines
+	Name string
+
+	// Title of the page, being its first heading if it has one, or its name
+	Title string
+
+	// Raw wiki text of the page
+	Text string
+
+	// Author and number of the latest version
+	Author  string
+	Version int
+
+	// Time of the latest modification, zero if unknown
+	LastModified time.Time
+
+	// URL of the page in the Trac web interface
+	URL string
+}
+
+var WIKI_HEADING_RE = regexp.MustCompile(`^\s*=+\s+(.+?)(?:\s+=+)?(?:\s+#\S+)?\s*$`)
+
+// FirstParagraph returns the first paragraph of text of the page, skipping
+// headings and macros.
+func (p WikiPage) FirstParagraph() string {
+	var paragraph []string
+
+	for _, line := range strings.Split(strings.Replace(p.Text, "\r\n", "\n", -1), "\n") {
+		trimmed := strings.TrimSpace(line)
+		isText := len(trimmed) > 0 && !WIKI_HEADING_RE.MatchString(line) && !(strings.HasPrefix(trimmed, "[[") && strings.HasSuffix(trimmed, "]]"))
+
+		if isText {
+			paragraph = append(paragraph, strings.TrimRight(line, " \t"))
+		} else if len(paragraph) > 0 {
+			break
+		}
+	}
+
+	return strings.Join(paragraph, "\n")
+}
+
+func wikiTitle(name, text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if match := WIKI_HEADING_RE.FindStringSubmatch(line); match != nil {
+			return match[1]
+		}
+	}
+
+	return name
+}
+
+// wikiPath returns the path to a wiki page, relative to the instance URL
+func wikiPath(name string) string {
+	segments := strings.Split(name, "/")
+
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	return "/wiki/" + strings.Join(segments, "/")
+}
+
+// GetWikiPage retrieves the latest version of a wiki page
+func (c *Client) GetWikiPage(name string) (WikiPage, error) {
+	if len(name) == 0 {
+		return WikiPage{}, errors.New("Empty wiki page name")
+	}
+
+	if c.rpc != nil {
+		page, err := c.getWikiPageRPC(name)
+
+		if errors.Cause(err) != errRPCUnavailable {
+			return page, err
+		}
+
+		log.Printf("RPC endpoint not available on %s, falling back to web interface", c.url)
+	}
+
+	return c.getWikiPageWeb(name)
+}
+
+func (c *Client) getWikiPageRPC(name string) (WikiPage, error) {
+	responses, err := c.rpcMulticall([]rpcRequest{
+		{"wiki.getPage", []interface{}{name}},
+		{"wiki.getPageInfo", []interface{}{name}},
+	})
+
+	if err != nil {
+		return WikiPage{}, err
+	}
+
+	for _, res := range responses {
+		if res.err != nil {
+			return WikiPage{}, errors.Wrapf(res.err, "Error while retrieving wiki page %s", name)
+		}
+	}
+
+	info, ok := responses[1].value.(map[string]interface{})
+
+	if !ok {
+		return WikiPage{}, errors.New("Unexpected page info structure in RPC response")
+	}
+
+	text := formatRPCValue(responses[0].value)
+	version, _ := info["version"].(int)
+
+	return WikiPage{
+		Name:         name,
+		Title:        wikiTitle(name, text),
+		Text:         text,
+		Author:       formatRPCValue(info["author"]),
+		Version:      version,
+		LastModified: rpcTime(info["lastModified"]),
+		URL:          c.url + wikiPath(name),
+	}, nil
+}
+
+var WIKI_VERSION_RE = regexp.MustCompile(`[?&]version=(\d+)`)
+
+func (c *Client) getWikiPageWeb(name string) (WikiPage, error) {
+	path := wikiPath(name)
+	text, err := c.get(path + "?format=txt")
+
+	if err == errNotFound {
+		return WikiPage{}, errors.Errorf("Wiki page %s does not exist", name)
+	}
+
+	if err != nil {
+		return WikiPage{}, errors.Wrapf(err, "Error while retrieving wiki page %s", name)
+	}
+
+	page := WikiPage{
+		Name:  name,
+		Title: wikiTitle(name, string(text)),
+		Text:  string(text),
+		URL:   c.url + path,
+	}
+
+	// The page history feed is our only source for the latest version
+	history, err := c.getRSS(path + "?action=history&format=rss")
+
+	if err != nil {
+		log.Printf("Error while retrieving history of wiki page %s: %s", name, err)
+	} else if len(history) > 0 {
+		page.Author = history[0].author()
+		page.LastModified = history[0].date()
+
+		if match := WIKI_VERSION_RE.FindStringSubmatch(history[0].Link); match != nil {
+			page.Version, _ = strconv.Atoi(match[1])
+		}
+	}
+
+	return page, nil
+}
@@ -0,0 +1,142 @@
+package trac
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const testWikiText = `[[PageOutline]]
+= Development guidelines =
+
+All code must be reviewed
+before being merged.
+
+== Style ==
+Use gofmt.
+`
+
+// sendPage queues a step answering a GET request for path with body
+func (s *TestServer) sendPage(path string, body string) {
+	s.steps = append(s.steps, func(req *http.Request) *http.Response {
+		if req.URL.String() != testUrl+path {
+			s.t.Errorf("Invalid URL: %s, expected %s", req.URL, testUrl+path)
+		}
+
+		res := makeResponse(http.StatusOK, req)
+		res.Body = ioutil.NopCloser(bytes.NewReader([]byte(body)))
+
+		return res
+	})
+}
+
+func TestWikiPageFirstParagraph(t *testing.T) {
+	page := WikiPage{Name: "Dev/Guidelines", Text: testWikiText}
+
+	if p := page.FirstParagraph(); p != "All code must be reviewed\nbefore being merged." {
+		t.Errorf("Unexpected first paragraph: %s", p)
+	}
+
+	if title := wikiTitle(page.Name, page.Text); title != "Development guidelines" {
+		t.Errorf("Unexpected title: %s", title)
+	}
+
+	if title := wikiTitle("Empty", ""); title != "Empty" {
+		t.Errorf("Unexpected title for page without heading: %s", title)
+	}
+}
+
+func TestGetWikiPageWeb(t *testing.T) {
+	s := testServer(t)
+	s.sendPage("/wiki/Dev/Guidelines?format=txt", testWikiText)
+	s.sendPage("/wiki/Dev/Guidelines?action=history&format=rss", `<?xml version="1.0"?>
+<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/">
+<channel>
+<title>Dev/Guidelines history</title>
+<item>
+<dc:creator>alice</dc:creator>
+<pubDate>Tue, 03 Jan 2017 11:30:00 GMT</pubDate>
+<title>Version 4</title>
+<link>`+testUrl+`/wiki/Dev/Guidelines?version=4</link>
+</item>
+<item>
+<dc:creator>bob</dc:creator>
+<pubDate>Mon, 02 Jan 2017 10:00:00 GMT</pubDate>
+<title>Version 3</title>
+<link>`+testUrl+`/wiki/Dev/Guidelines?version=3</link>
+</item>
+</channel>
+</rss>`)
+
+	client, err := NewWithHttpClient(testUrl, AuthBasic, false, s)
+
+	if err != nil {
+		t.Fatalf("Error while creating client: %s", err)
+	}
+
+	page, err := client.GetWikiPage("Dev/Guidelines")
+
+	if err != nil {
+		t.Fatalf("GetWikiPage failed: %s", err)
+	}
+
+	if page.Title != "Development guidelines" || page.Author != "alice" || page.Version != 4 {
+		t.Errorf("Unexpected page: %v", page)
+	}
+
+	if !page.LastModified.Equal(time.Date(2017, 1, 3, 11, 30, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected modification time: %s", page.LastModified)
+	}
+
+	if page.URL != testUrl+"/wiki/Dev/Guidelines" {
+		t.Errorf("Unexpected page URL: %s", page.URL)
+	}
+}
+
+func TestGetWikiPageNotFound(t *testing.T) {
+	s := testServer(t)
+	s.notFound()
+
+	client, err := NewWithHttpClient(testUrl, AuthBasic, false, s)
+
+	if err != nil {
+		t.Fatalf("Error while creating client: %s", err)
+	}
+
+	if _, err := client.GetWikiPage("Missing"); err == nil || err.Error() != "Wiki page Missing does not exist" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestGetWikiPageRPC(t *testing.T) {
+	s := testServer(t)
+	s.authenticate()
+	s.xmlRPC("system.multicall", []interface{}{[]interface{}{
+		map[string]interface{}{"methodName": "wiki.getPage", "params": []interface{}{"WikiStart"}},
+		map[string]interface{}{"methodName": "wiki.getPageInfo", "params": []interface{}{"WikiStart"}},
+	}}, `<?xml version='1.0'?>
+<methodResponse><params><param><value><array><data>
+<value><array><data><value><string>= Welcome =
+Hello world</string></value></data></array></value>
+<value><array><data><value><struct>
+<member><name>name</name><value><string>WikiStart</string></value></member>
+<member><name>author</name><value><string>carol</string></value></member>
+<member><name>version</name><value><int>7</int></value></member>
+<member><name>lastModified</name><value><dateTime.iso8601>20170103T11:30:00</dateTime.iso8601></value></member>
+</struct></value></data></array></value>
+</data></array></value></param></params></methodResponse>`)
+
+	client := newXMLRPCTestClient(t, s)
+
+	page, err := client.GetWikiPage("WikiStart")
+
+	if err != nil {
+		t.Fatalf("GetWikiPage failed: %s", err)
+	}
+
+	if page.Title != "Welcome" || page.FirstParagraph() != "Hello world" || page.Author != "carol" || page.Version != 7 {
+		t.Errorf("Unexpected page: %v", page)
+	}
+}
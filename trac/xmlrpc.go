@@ -0,0 +1,360 @@
+package trac
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// xmlRPCTransport talks to the XML-RPC endpoint of TracXMLRPCPlugin
+type xmlRPCTransport struct {
+	client *Client
+}
+
+const xmlRPCDateFormat = "20060102T15:04:05"
+
+func (t *xmlRPCTransport) call(method string, params ...interface{}) (interface{}, error) {
+	body, err := encodeXMLRPCCall(method, params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := t.client.rpcPost("rpc", "text/xml", body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeXMLRPCResponse(data)
+}
+
+func (t *xmlRPCTransport) multicall(requests []rpcRequest) ([]rpcResponse, error) {
+	calls := make([]interface{}, len(requests))
+
+	for i, req := range requests {
+		params := req.params
+
+		if params == nil {
+			params = []interface{}{}
+		}
+
+		calls[i] = map[string]interface{}{
+			"methodName": req.method,
+			"params":     params,
+		}
+	}
+
+	res, err := t.call("system.multicall", calls)
+
+	if err != nil {
+		return nil, err
+	}
+
+	list, ok := res.([]interface{})
+
+	if !ok {
+		return nil, errors.New("Unexpected multicall response structure")
+	}
+
+	responses := make([]rpcResponse, len(list))
+
+	for i, item := range list {
+		// Successful calls are wrapped in a single element array, failed ones
+		// are represented by a fault struct.
+		switch item := item.(type) {
+		case []interface{}:
+			if len(item) != 1 {
+				return nil, errors.New("Unexpected multicall result structure")
+			}
+
+			responses[i].value = item[0]
+		case map[string]interface{}:
+			responses[i].err = faultFromXMLRPC(item)
+		default:
+			return nil, errors.New("Unexpected multicall result structure")
+		}
+	}
+
+	return responses, nil
+}
+
+func encodeXMLRPCCall(method string, params []interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+
+	buf.WriteString(xml.Header)
+	buf.WriteString("<methodCall><methodName>")
+	xml.EscapeText(buf, []byte(method))
+	buf.WriteString("</methodName><params>")
+
+	for _, param := range params {
+		buf.WriteString("<param>")
+
+		if err := encodeXMLRPCValue(buf, param); err != nil {
+			return nil, errors.Wrapf(err, "Error while encoding parameters for %s", method)
+		}
+
+		buf.WriteString("</param>")
+	}
+
+	buf.WriteString("</params></methodCall>")
+
+	return buf.Bytes(), nil
+}
+
+func encodeXMLRPCValue(buf *bytes.Buffer, v interface{}) error {
+	buf.WriteString("<value>")
+
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("<nil/>")
+	case string:
+		buf.WriteString("<string>")
+		xml.EscapeText(buf, []byte(v))
+		buf.WriteString("</string>")
+	case int:
+		fmt.Fprintf(buf, "<int>%d</int>", v)
+	case int64:
+		fmt.Fprintf(buf, "<int>%d</int>", v)
+	case bool:
+		if v {
+			buf.WriteString("<boolean>1</boolean>")
+		} else {
+			buf.WriteString("<boolean>0</boolean>")
+		}
+	case float64:
+		fmt.Fprintf(buf, "<double>%s</double>", strconv.FormatFloat(v, 'f', -1, 64))
+	case time.Time:
+		fmt.Fprintf(buf, "<dateTime.iso8601>%s</dateTime.iso8601>", v.UTC().Format(xmlRPCDateFormat))
+	case []byte:
+		fmt.Fprintf(buf, "<base64>%s</base64>", base64.StdEncoding.EncodeToString(v))
+	case []string:
+		buf.WriteString("<array><data>")
+
+		for _, x := range v {
+			encodeXMLRPCValue(buf, x)
+		}
+
+		buf.WriteString("</data></array>")
+	case []interface{}:
+		buf.WriteString("<array><data>")
+
+		for _, x := range v {
+			if err := encodeXMLRPCValue(buf, x); err != nil {
+				return err
+			}
+		}
+
+		buf.WriteString("</data></array>")
+	case map[string]string:
+		m := make(map[string]interface{}, len(v))
+
+		for key, x := range v {
+			m[key] = x
+		}
+
+		return encodeXMLRPCStruct(buf, m)
+	case map[string]interface{}:
+		return encodeXMLRPCStruct(buf, v)
+	default:
+		return errors.Errorf("Cannot encode value of type %T", v)
+	}
+
+	buf.WriteString("</value>")
+
+	return nil
+}
+
+func encodeXMLRPCStruct(buf *bytes.Buffer, v map[string]interface{}) error {
+	keys := make([]string, 0, len(v))
+
+	for key := range v {
+		keys = append(keys, key)
+	}
+
+	// Keep the output stable, mostly for the sake of tests
+	sort.Strings(keys)
+
+	buf.WriteString("<struct>")
+
+	for _, key := range keys {
+		buf.WriteString("<member><name>")
+		xml.EscapeText(buf, []byte(key))
+		buf.WriteString("</name>")
+
+		if err := encodeXMLRPCValue(buf, v[key]); err != nil {
+			return err
+		}
+
+		buf.WriteString("</member>")
+	}
+
+	buf.WriteString("</struct></value>")
+
+	return nil
+}
+
+type xmlRPCValue struct {
+	String   *string       `xml:"string"`
+	Int      *string       `xml:"int"`
+	I4       *string       `xml:"i4"`
+	I8       *string       `xml:"i8"`
+	Boolean  *string       `xml:"boolean"`
+	Double   *string       `xml:"double"`
+	DateTime *string       `xml:"dateTime.iso8601"`
+	Base64   *string       `xml:"base64"`
+	Nil      *struct{}     `xml:"nil"`
+	Array    *xmlRPCArray  `xml:"array"`
+	Struct   *xmlRPCStruct `xml:"struct"`
+	Text     string        `xml:",chardata"`
+}
+
+type xmlRPCArray struct {
+	Values []xmlRPCValue `xml:"data>value"`
+}
+
+type xmlRPCStruct struct {
+	Members []xmlRPCMember `xml:"member"`
+}
+
+type xmlRPCMember struct {
+	Name  string      `xml:"name"`
+	Value xmlRPCValue `xml:"value"`
+}
+
+type xmlRPCResponse struct {
+	Params []xmlRPCValue `xml:"params>param>value"`
+	Fault  *xmlRPCValue  `xml:"fault>value"`
+}
+
+func decodeXMLRPCResponse(data []byte) (interface{}, error) {
+	var res xmlRPCResponse
+
+	if err := xml.Unmarshal(data, &res); err != nil {
+		return nil, errors.Wrap(err, "Error while decoding XML-RPC response")
+	}
+
+	if res.Fault != nil {
+		fault, err := res.Fault.decode()
+
+		if err != nil {
+			return nil, errors.Wrap(err, "Error while decoding XML-RPC fault")
+		}
+
+		return nil, faultFromXMLRPC(fault)
+	}
+
+	if len(res.Params) != 1 {
+		return nil, errors.Errorf("Unexpected number of values in XML-RPC response: %d", len(res.Params))
+	}
+
+	return res.Params[0].decode()
+}
+
+func faultFromXMLRPC(v interface{}) *RPCFault {
+	attributes, _ := v.(map[string]interface{})
+	code, _ := attributes["faultCode"].(int)
+
+	return &RPCFault{
+		Code:    code,
+		Message: formatRPCValue(attributes["faultString"]),
+	}
+}
+
+func parseXMLRPCDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+
+	for _, layout := range []string{xmlRPCDateFormat, xmlRPCDateFormat + "Z07:00", "2006-01-02T15:04:05Z07:00"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errors.Errorf("Invalid date: %s", s)
+}
+
+func (v *xmlRPCValue) decode() (interface{}, error) {
+	switch {
+	case v.String != nil:
+		return *v.String, nil
+	case v.Int != nil, v.I4 != nil, v.I8 != nil:
+		s := v.Int
+
+		if s == nil {
+			s = v.I4
+		}
+
+		if s == nil {
+			s = v.I8
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(*s))
+
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid integer")
+		}
+
+		return n, nil
+	case v.Boolean != nil:
+		return strings.TrimSpace(*v.Boolean) == "1", nil
+	case v.Double != nil:
+		f, err := strconv.ParseFloat(strings.TrimSpace(*v.Double), 64)
+
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid double")
+		}
+
+		return f, nil
+	case v.DateTime != nil:
+		return parseXMLRPCDate(*v.DateTime)
+	case v.Base64 != nil:
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(*v.Base64))
+
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid base64 data")
+		}
+
+		return data, nil
+	case v.Nil != nil:
+		return nil, nil
+	case v.Array != nil:
+		values := make([]interface{}, len(v.Array.Values))
+
+		for i := range v.Array.Values {
+			x, err := v.Array.Values[i].decode()
+
+			if err != nil {
+				return nil, err
+			}
+
+			values[i] = x
+		}
+
+		return values, nil
+	case v.Struct != nil:
+		values := make(map[string]interface{}, len(v.Struct.Members))
+
+		for i := range v.Struct.Members {
+			member := &v.Struct.Members[i]
+			x, err := member.Value.decode()
+
+			if err != nil {
+				return nil, err
+			}
+
+			values[member.Name] = x
+		}
+
+		return values, nil
+	default:
+		// Untyped values are strings
+		return v.Text, nil
+	}
+}
@@ -0,0 +1,339 @@
+package trac
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type xmlRPCTestCall struct {
+	MethodName string        `xml:"methodName"`
+	Params     []xmlRPCValue `xml:"params>param>value"`
+}
+
+// xmlRPC queues a step answering an XML-RPC call to method with the given
+// response body
+func (s *TestServer) xmlRPC(method string, params []interface{}, response string) {
+	s.steps = append(s.steps, func(req *http.Request) *http.Response {
+		const rpcUrl = testUrl + "/login/rpc"
+
+		if req.URL.String() != rpcUrl {
+			s.t.Errorf("Invalid RPC URL: %s", req.URL)
+		}
+
+		if !s.authenticated {
+			return makeResponse(http.StatusForbidden, req)
+		}
+
+		body, _ := ioutil.ReadAll(req.Body)
+
+		var call xmlRPCTestCall
+
+		if err := xml.Unmarshal(body, &call); err != nil {
+			s.t.Errorf("Invalid XML-RPC request: %s", err)
+			return makeResponse(http.StatusBadRequest, req)
+		}
+
+		if call.MethodName != method {
+			s.t.Errorf("Unexpected RPC method %s, expected %s", call.MethodName, method)
+		}
+
+		if len(call.Params) != len(params) {
+			s.t.Errorf("Unexpected number of RPC parameters for %s: %d", method, len(call.Params))
+		} else {
+			for i, param := range call.Params {
+				if v, _ := param.decode(); formatRPCValue(v) != formatRPCValue(params[i]) {
+					s.t.Errorf("Unexpected RPC parameter %d for %s: %v", i, method, v)
+				}
+			}
+		}
+
+		res := makeResponse(http.StatusOK, req)
+		res.Body = ioutil.NopCloser(bytes.NewReader([]byte(response)))
+
+		return res
+	})
+}
+
+func (s *TestServer) notFound() {
+	s.steps = append(s.steps, func(req *http.Request) *http.Response {
+		return makeResponse(http.StatusNotFound, req)
+	})
+}
+
+const xmlRPCTicketValue = `<value><array><data>
+<value><int>33</int></value>
+<value><dateTime.iso8601>20170102T10:00:00</dateTime.iso8601></value>
+<value><dateTime.iso8601>20170103T11:30:00</dateTime.iso8601></value>
+<value><struct>
+<member><name>summary</name><value><string>Test &amp; ticket</string></value></member>
+<member><name>status</name><value>new</value></member>
+<member><name>changetime</name><value><dateTime.iso8601>20170103T11:30:00</dateTime.iso8601></value></member>
+<member><name>_ts</name><value><string>1483443000000000</string></value></member>
+</struct></value>
+</data></array></value>`
+
+const xmlRPCTicketResponse = `<?xml version='1.0'?>
+<methodResponse><params><param>` + xmlRPCTicketValue + `</param></params></methodResponse>`
+
+func newXMLRPCTestClient(t *testing.T, s *TestServer) *Client {
+	client, err := NewWithHttpClient(testUrl, AuthBasic, false, s)
+
+	if err != nil {
+		t.Fatalf("Error while creating client: %s", err)
+	}
+
+	client.SetBackend(BackendXMLRPC)
+
+	if err := client.Authenticate(testUsername, testPassword); err != nil {
+		t.Fatalf("Authenticate failed: %s", err)
+	}
+
+	return client
+}
+
+func TestXMLRPCGetTicket(t *testing.T) {
+	s := testServer(t)
+	s.authenticate()
+	s.xmlRPC("ticket.get", []interface{}{33}, xmlRPCTicketResponse)
+
+	client := newXMLRPCTestClient(t, s)
+
+	ticket, err := client.GetTicket("33")
+
+	if err != nil {
+		t.Fatalf("GetTicket failed: %s", err)
+	}
+
+	expected := Ticket{
+		"id":         "33",
+		"summary":    "Test & ticket",
+		"status":     "new",
+		"changetime": "2017-01-03 11:30:00",
+		"_ts":        "1483443000000000",
+		"_url":       testUrl + "/ticket/33",
+	}
+
+	if len(ticket) != len(expected) {
+		t.Errorf("Unexpected ticket fields: %v", ticket)
+	}
+
+	for field, value := range expected {
+		if ticket[field] != value {
+			t.Errorf("Unexpected value for field %s: '%s', expected '%s'", field, ticket[field], value)
+		}
+	}
+}
+
+func TestXMLRPCFault(t *testing.T) {
+	s := testServer(t)
+	s.authenticate()
+	s.xmlRPC("ticket.get", []interface{}{404}, `<?xml version='1.0'?>
+<methodResponse><fault><value><struct>
+<member><name>faultCode</name><value><int>404</int></value></member>
+<member><name>faultString</name><value><string>Ticket 404 does not exist.</string></value></member>
+</struct></value></fault></methodResponse>`)
+
+	client := newXMLRPCTestClient(t, s)
+
+	_, err := client.GetTicket("404")
+
+	if err == nil {
+		t.Fatalf("GetTicket should have failed")
+	}
+
+	fault, ok := errors.Cause(err).(*RPCFault)
+
+	if !ok {
+		t.Fatalf("Expected an RPC fault, got %s", err)
+	}
+
+	if fault.Code != 404 || fault.Message != "Ticket 404 does not exist." {
+		t.Errorf("Unexpected fault: %v", fault)
+	}
+}
+
+func TestXMLRPCFallbackToCSV(t *testing.T) {
+	s := testServer(t)
+	s.authenticate()
+	s.notFound()
+	s.sendTicket()
+
+	client := newXMLRPCTestClient(t, s)
+
+	ticket, err := client.GetTicket("33")
+
+	if err != nil {
+		t.Fatalf("GetTicket failed: %s", err)
+	}
+
+	if ticket["summary"] != "Test ticket" {
+		t.Errorf("Unexpected ticket summary: %s", ticket["summary"])
+	}
+}
+
+func TestXMLRPCReauthenticate(t *testing.T) {
+	s := testServer(t)
+	s.authenticate()
+	s.xmlRPC("ticket.get", []interface{}{33}, "")
+	s.authenticate()
+	s.xmlRPC("ticket.get", []interface{}{33}, xmlRPCTicketResponse)
+
+	client := newXMLRPCTestClient(t, s)
+
+	// Simulate deauthentication
+	s.authenticated = false
+
+	if _, err := client.GetTicket("33"); err != nil {
+		t.Errorf("GetTicket failed: %s", err)
+	}
+}
+
+func TestXMLRPCTicketFields(t *testing.T) {
+	s := testServer(t)
+	s.authenticate()
+	s.xmlRPC("ticket.getTicketFields", nil, `<?xml version='1.0'?>
+<methodResponse><params><param><value><array><data>
+<value><struct>
+<member><name>name</name><value><string>summary</string></value></member>
+<member><name>label</name><value><string>Summary</string></value></member>
+<member><name>type</name><value><string>text</string></value></member>
+</struct></value>
+<value><struct>
+<member><name>name</name><value><string>component</string></value></member>
+<member><name>label</name><value><string>Component</string></value></member>
+<member><name>type</name><value><string>select</string></value></member>
+<member><name>options</name><value><array><data><value>core</value><value>ui</value></data></array></value></member>
+</struct></value>
+<value><struct>
+<member><name>name</name><value><string>customer</string></value></member>
+<member><name>label</name><value><string>Customer</string></value></member>
+<member><name>type</name><value><string>text</string></value></member>
+<member><name>custom</name><value><boolean>1</boolean></value></member>
+</struct></value>
+</data></array></value></param></params></methodResponse>`)
+
+	client := newXMLRPCTestClient(t, s)
+
+	fields, err := client.GetTicketFields()
+
+	if err != nil {
+		t.Fatalf("GetTicketFields failed: %s", err)
+	}
+
+	if len(fields) != 3 {
+		t.Fatalf("Unexpected number of fields: %d", len(fields))
+	}
+
+	if fields[1].Name != "component" || fields[1].Type != "select" || len(fields[1].Options) != 2 || fields[1].Options[1] != "ui" {
+		t.Errorf("Unexpected component field: %v", fields[1])
+	}
+
+	if fields[0].Custom || !fields[2].Custom {
+		t.Errorf("Unexpected custom flags: %v", fields)
+	}
+}
+
+func TestXMLRPCChangelog(t *testing.T) {
+	s := testServer(t)
+	s.authenticate()
+	s.xmlRPC("ticket.changeLog", []interface{}{33}, `<?xml version='1.0'?>
+<methodResponse><params><param><value><array><data>
+<value><array><data>
+<value><dateTime.iso8601>20170103T11:30:00</dateTime.iso8601></value>
+<value><string>alice</string></value>
+<value><string>status</string></value>
+<value><string>new</string></value>
+<value><string>assigned</string></value>
+<value><int>1</int></value>
+</data></array></value>
+<value><array><data>
+<value><dateTime.iso8601>20170103T11:30:00</dateTime.iso8601></value>
+<value><string>alice</string></value>
+<value><string>comment</string></value>
+<value><string>1</string></value>
+<value><string>Looking into it</string></value>
+<value><int>1</int></value>
+</data></array></value>
+</data></array></value></param></params></methodResponse>`)
+
+	client := newXMLRPCTestClient(t, s)
+
+	changes, err := client.GetTicketChangelog("33")
+
+	if err != nil {
+		t.Fatalf("GetTicketChangelog failed: %s", err)
+	}
+
+	if len(changes) != 2 {
+		t.Fatalf("Unexpected number of changes: %d", len(changes))
+	}
+
+	expectedTime := time.Date(2017, 1, 3, 11, 30, 0, 0, time.UTC)
+
+	if !changes[0].Time.Equal(expectedTime) || changes[0].Author != "alice" || changes[0].NewValue != "assigned" || !changes[0].Permanent {
+		t.Errorf("Unexpected first change: %v", changes[0])
+	}
+
+	if changes[1].Field != "comment" || changes[1].NewValue != "Looking into it" {
+		t.Errorf("Unexpected second change: %v", changes[1])
+	}
+}
+
+func TestXMLRPCEncodeCall(t *testing.T) {
+	body, err := encodeXMLRPCCall("ticket.update", []interface{}{
+		33,
+		"a <comment>",
+		map[string]interface{}{"status": "closed", "notify": true},
+	})
+
+	if err != nil {
+		t.Fatalf("encodeXMLRPCCall failed: %s", err)
+	}
+
+	expected := xml.Header + `<methodCall><methodName>ticket.update</methodName><params>` +
+		`<param><value><int>33</int></value></param>` +
+		`<param><value><string>a &lt;comment&gt;</string></value></param>` +
+		`<param><value><struct>` +
+		`<member><name>notify</name><value><boolean>1</boolean></value></member>` +
+		`<member><name>status</name><value><string>closed</string></value></member>` +
+		`</struct></value></param>` +
+		`</params></methodCall>`
+
+	if string(body) != expected {
+		t.Errorf("Unexpected XML-RPC call:\n%s\nexpected:\n%s", body, expected)
+	}
+}
+
+func TestXMLRPCGetTickets(t *testing.T) {
+	s := testServer(t)
+	s.authenticate()
+	s.xmlRPC("system.multicall", []interface{}{[]interface{}{
+		map[string]interface{}{"methodName": "ticket.get", "params": []interface{}{33}},
+		map[string]interface{}{"methodName": "ticket.get", "params": []interface{}{404}},
+	}}, `<?xml version='1.0'?>
+<methodResponse><params><param><value><array><data>
+<value><array><data>`+xmlRPCTicketValue+`</data></array></value>
+<value><struct>
+<member><name>faultCode</name><value><int>404</int></value></member>
+<member><name>faultString</name><value><string>Ticket 404 does not exist.</string></value></member>
+</struct></value>
+</data></array></value></param></params></methodResponse>`)
+
+	client := newXMLRPCTestClient(t, s)
+
+	tickets, errs := client.GetTickets([]string{"33", "404"})
+
+	if errs[0] != nil || tickets[0]["summary"] != "Test & ticket" {
+		t.Errorf("Unexpected first result: %v, %v", tickets[0], errs[0])
+	}
+
+	if fault, ok := errors.Cause(errs[1]).(*RPCFault); !ok || fault.Code != 404 {
+		t.Errorf("Expected an RPC fault for the second ticket, got %v", errs[1])
+	}
+}